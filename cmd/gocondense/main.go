@@ -5,6 +5,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -16,11 +17,15 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"golang.org/x/mod/modfile"
 	"golang.org/x/sync/semaphore"
@@ -50,8 +55,66 @@ func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
 	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
 	flags.SetOutput(stderr)
 
-	maxLen := flags.Int("max-len", 80, "maximum line length before keeping multi-line")
+	maxLen := flags.Int("max-len", 80, "maximum line length before keeping multi-line (0 for no limit)")
+	excludeIndent := flags.Bool("max-len-excludes-indent", false, "measure lines against max-len without their indentation")
 	tabWidth := flags.Int("tab-width", 4, "width of a tab character for line length calculation")
+	minLinesSaved := flags.Int("min-lines-saved", 0, "minimum number of lines a construct must shrink by to be condensed")
+	maxItems := flags.Int("max-condense-items", 0, "maximum number of unkeyed literal elements or call arguments to condense (0 for no limit)")
+	maxNestDepth := flags.Int("max-nest-depth", 0, "maximum number of calls and composite literals nested on a condensed line (0 for no limit)")
+	maxChanges := flags.Int("max-changes-per-file", 0, "maximum number of constructs to condense in each file (0 for no limit)")
+	preserveIota := flags.Bool("preserve-iota-blocks", false, "keep single-spec const groups that use iota")
+	strictComments := flags.Bool("strict-comments", false, "never condense constructs containing comments")
+	normalize := flags.Bool("normalize", false, "format input with gofmt before condensing")
+	var exclude []string
+	flags.Func("exclude-name", "leave the declaration of the function, type, variable or constant `name` as is; may be repeated", func(s string) error {
+		if s == "" {
+			return errors.New("empty name")
+		}
+		exclude = append(exclude, s)
+		return nil
+	})
+	var alwaysCalls []string
+	flags.Func("always-condense-call", "always condense calls to the function `name`, e.g. fmt.Errorf, if they fit; may be repeated", func(s string) error {
+		if s == "" {
+			return errors.New("empty name")
+		}
+		alwaysCalls = append(alwaysCalls, s)
+		return nil
+	})
+	enable, disable := gocondense.All, gocondense.Feature(0)
+	flags.TextVar(&enable, "enable", enable, "comma-separated `features` to condense; slices and declarations no longer imply arrays and imports")
+	flags.TextVar(&disable, "disable", disable, "comma-separated `features` not to condense")
+	var testDisable gocondense.Feature
+	flags.TextVar(&testDisable, "test-disable", testDisable, "comma-separated `features` not to condense in _test.go files")
+	testMaxItems := flags.Int("test-max-condense-items", 0, "maximum-condense-items for _test.go files; defaults to -max-condense-items")
+	var finalNewline gocondense.Newline
+	flags.TextVar(&finalNewline, "final-newline", finalNewline, "final newline `mode`: always, keep or never")
+	processGenerated := flags.Bool("process-generated", false, "format generated files found in directories or listed by -files-from, -staged or -diff-base")
+	var generatedMarkers []string
+	flags.Func("generated-marker", "also treat files with `text` in a comment before the package clause as generated; may be repeated", func(s string) error {
+		if s == "" {
+			return errors.New("empty marker")
+		}
+		generatedMarkers = append(generatedMarkers, s)
+		return nil
+	})
+	serveMode := flags.Bool("serve", false, "serve formatting requests over stdin and stdout")
+	filesFrom := flags.String("files-from", "", "read newline-separated Go files to format from `file`, or - for stdin")
+	staged := flags.Bool("staged", false, "format only files staged in git and re-stage them, skipping files with unstaged changes")
+	diffBase := flags.String("diff-base", "", "condense only lines changed since git `ref`; defaults to the files changed since it")
+	var since time.Time
+	flags.Func("since", "only process files modified within a `duration` such as 24h or since a timestamp such as 2006-01-02", func(s string) error {
+		var err error
+		since, err = parseSince(s, time.Now())
+		return err
+	})
+	verbose := flags.Bool("verbose", false, "print each condensed construct to stderr")
+	failFast := flags.Bool("fail-fast", false, "stop processing files after the first error")
+	printConfig := flags.Bool("print-config", false, "print the resolved configuration as JSON and exit; -test-disable and -test-max-condense-items are not included")
+	check := flags.Bool("check", false, "list files that need condensing instead of writing them, and exit 1 if there are any")
+	exitZero := flags.Bool("exit-zero", false, "with -check, exit 0 even if files need condensing")
+	exitOnChange := flags.Bool("exit-code-on-change", false, "exit 1 if any file was condensed and written")
+	reportFormat := flags.String("format", "", "report `format` for files; json prints condensable constructs instead of writing")
 
 	flags.Usage = func() {
 		fmt.Fprintf(stderr, "Usage: %s [options] [file|dir|path/...]", args[0])
@@ -59,6 +122,15 @@ func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
 		fmt.Fprintf(stderr, "If no file is provided, reads from stdin and writes to stdout.\n\n")
 		fmt.Fprintf(stderr, "Options:\n")
 		flags.PrintDefaults()
+		fmt.Fprintf(stderr, "\nFeatures (for -enable and -disable, all enabled by default):\n")
+		for _, name := range featureNames() {
+			fmt.Fprintf(stderr, "  %s\n", name)
+		}
+		fmt.Fprintf(stderr, "\nGroups:\n")
+		fmt.Fprintf(stderr, "  all\n")
+		fmt.Fprintf(stderr, "  body-literals  %s\n", gocondense.BodyLiterals)
+		fmt.Fprintf(stderr, "  funcs          %s\n", gocondense.Funcs)
+		fmt.Fprintf(stderr, "  signatures     %s\n", gocondense.Signatures)
 	}
 
 	if err := flags.Parse(args[1:]); err != nil {
@@ -74,44 +146,378 @@ func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
 		return 2
 	}
 
-	formatter := gocondense.New(gocondense.Config{
-		MaxLen:   *maxLen,
-		TabWidth: *tabWidth,
+	if *maxItems < 0 || *testMaxItems < 0 {
+		fmt.Fprintf(stderr, "max-condense-items and test-max-condense-items must not be negative\n")
+		flags.Usage()
+		return 2
+	}
+
+	features := enable &^ disable
+	if features == 0 {
+		fmt.Fprintf(stderr, "no features enabled\n")
+		flags.Usage()
+		return 2
+	}
+
+	if *reportFormat != "" && *reportFormat != "json" {
+		fmt.Fprintf(stderr, "unsupported format %q\n", *reportFormat)
+		flags.Usage()
+		return 2
+	}
+
+	if *check && *reportFormat != "" {
+		fmt.Fprintf(stderr, "check cannot be used with format\n")
+		flags.Usage()
+		return 2
+	}
+
+	if *maxLen == 0 {
+		*maxLen = -1 // Config treats 0 as the default and negative as no limit.
+	}
+
+	config := gocondense.Config{
+		MaxLen:               *maxLen,
+		MaxLenExcludesIndent: *excludeIndent,
+		TabWidth:             *tabWidth,
+		Features:             features,
+		MinLinesSaved:        *minLinesSaved,
+		MaxCondenseItems:     *maxItems,
+		MaxNestDepth:         *maxNestDepth,
+		MaxChangesPerFile:    *maxChanges,
+		Exclude:              exclude,
+		AlwaysCondenseCalls:  alwaysCalls,
+		PreserveIotaBlocks:   *preserveIota,
+		StrictComments:       *strictComments,
+		Normalize:            *normalize,
+		FinalNewline:         finalNewline,
+	}
+	formatter := gocondense.New(config)
+
+	testConfig := config
+	testConfig.Features &^= testDisable
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "test-max-condense-items" {
+			testConfig.MaxCondenseItems = *testMaxItems
+		}
 	})
+	if testConfig.Features == 0 {
+		fmt.Fprintf(stderr, "no features enabled for test files\n")
+		flags.Usage()
+		return 2
+	}
+	testFormatter := gocondense.New(testConfig)
+
+	if *printConfig {
+		out, err := json.MarshalIndent(formatter.Config(), "", "  ")
+		if err != nil {
+			fmt.Fprintf(stderr, "Error printing config: %v\n", err)
+			return 2
+		}
+		fmt.Fprintf(stdout, "%s\n", out)
+		return 0
+	}
+
+	paths := flags.Args()
+	if *serveMode {
+		if len(paths) > 0 {
+			fmt.Fprintf(stderr, "serve cannot be used with file arguments\n")
+			flags.Usage()
+			return 2
+		}
+		if err := serve(formatter, testFormatter, stdin, stdout); err != nil {
+			fmt.Fprintf(stderr, "Error serving: %v\n", err)
+			return 2
+		}
+		return 0
+	}
+
+	if *filesFrom != "" {
+		if len(paths) > 0 {
+			fmt.Fprintf(stderr, "files-from cannot be used with file arguments\n")
+			flags.Usage()
+			return 2
+		}
+		files, err := readFileList(*filesFrom, stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error reading file list: %v\n", err)
+			return 2
+		}
+		if len(files) == 0 {
+			return 0
+		}
+		paths = files
+	}
+
+	if *staged {
+		if len(paths) > 0 {
+			fmt.Fprintf(stderr, "staged cannot be used with file arguments\n")
+			flags.Usage()
+			return 2
+		}
+		files, err := stagedFiles()
+		if err != nil {
+			fmt.Fprintf(stderr, "Error listing staged files: %v\n", err)
+			return 2
+		}
+		// Re-staging a file stages all of it, so leave partly staged files
+		// alone rather than commit their unstaged changes.
+		unstaged, err := unstagedFiles()
+		if err != nil {
+			fmt.Fprintf(stderr, "Error listing unstaged files: %v\n", err)
+			return 2
+		}
+		files = slices.DeleteFunc(files, func(name string) bool {
+			if !slices.Contains(unstaged, name) {
+				return false
+			}
+			fmt.Fprintf(stderr, "Skipping file %s: it has unstaged changes\n", name)
+			return true
+		})
+		if len(files) == 0 {
+			return 0
+		}
+		paths = files
+	}
+
+	listed := *filesFrom != "" || *staged
+	if *diffBase != "" && len(paths) == 0 {
+		files, err := changedFiles(*diffBase)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error listing changed files: %v\n", err)
+			return 2
+		}
+		if len(files) == 0 {
+			return 0
+		}
+		paths = files
+		listed = true
+	}
+
+	if len(paths) == 0 {
+		if *check {
+			fmt.Fprintf(stderr, "check requires file arguments\n")
+			flags.Usage()
+			return 2
+		}
+		return formatStdin(formatter, stdin, stdout, stderr, *verbose, *reportFormat == "json")
+	}
+
+	p := &processor{
+		formatter:        formatter,
+		testFormatter:    testFormatter,
+		stdout:           stdout,
+		stderr:           stderr,
+		processGenerated: *processGenerated,
+		generatedMarkers: generatedMarkers,
+		staged:           *staged,
+		listed:           listed,
+		diffBase:         *diffBase,
+		since:            since,
+		verbose:          *verbose,
+		check:            *check,
+		exitZero:         *exitZero,
+		exitOnChange:     *exitOnChange,
+		failFast:         *failFast,
+	}
+	if *reportFormat == "json" {
+		p.reports = []report{}
+	}
+	code := p.processArgs(paths)
+
+	if p.staged && len(p.written) > 0 {
+		slices.Sort(p.written)
+		if _, err := git(append([]string{"add", "--"}, p.written...)...); err != nil {
+			fmt.Fprintf(stderr, "Error staging files: %v\n", err)
+			return 2
+		}
+	}
+	return code
+}
+
+// git runs git with the given arguments in the current directory and returns
+// its standard output. It is a variable so tests can replace it.
+var git = func(args ...string) ([]byte, error) {
+	out, err := exec.Command("git", args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(exitErr.Stderr))
+	}
+	return out, err
+}
+
+// featureNames returns the names of the individual features in alphabetical
+// order.
+func featureNames() []string {
+	var names []string
+	for f := gocondense.Feature(1); f&gocondense.All != 0; f <<= 1 {
+		names = append(names, f.String())
+	}
+	slices.Sort(names)
+	return names
+}
+
+// stagedFiles returns the Go files added, copied, or modified in the git
+// index, relative to the current directory.
+func stagedFiles() ([]string, error) {
+	return diffFiles("--cached")
+}
+
+// unstagedFiles returns the Go files modified in the working tree but not in
+// the git index, relative to the current directory.
+func unstagedFiles() ([]string, error) {
+	return diffFiles()
+}
+
+// changedFiles returns the Go files added, copied, or modified in the working
+// tree since the given git ref, relative to the current directory.
+func changedFiles(base string) ([]string, error) {
+	return diffFiles(base)
+}
+
+// diffFiles returns the Go files added, copied, or modified in git diff with
+// the given arguments, relative to the current directory.
+func diffFiles(args ...string) ([]string, error) {
+	args = append([]string{"diff", "--name-only", "--relative", "-z", "--diff-filter=ACM"}, args...)
+	out, err := git(append(args, "--", "*.go")...)
+	if err != nil {
+		return nil, err
+	}
+	return parseStaged(out), nil
+}
+
+// changedLines returns the lines of filename changed since the given git ref.
+func changedLines(base, filename string) ([]gocondense.LineRange, error) {
+	out, err := git("diff", "-U0", "--no-color", "--no-ext-diff", base, "--", filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseDiffLines(out), nil
+}
+
+// parseDiffLines parses the hunk headers of a unified diff with no context,
+// returning the line ranges of the new file they cover. A hunk that only
+// deletes lines covers the line before the deletion.
+func parseDiffLines(out []byte) []gocondense.LineRange {
+	var ranges []gocondense.LineRange
+	for line := range strings.Lines(string(out)) {
+		// e.g. "@@ -10,2 +12,3 @@ func main() {"
+		hunk, ok := strings.CutPrefix(line, "@@ ")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(hunk)
+		if len(fields) < 2 || !strings.HasPrefix(fields[1], "+") {
+			continue
+		}
+		startText, countText, hasCount := strings.Cut(fields[1][1:], ",")
+		start, err := strconv.Atoi(startText)
+		if err != nil {
+			continue
+		}
+		count := 1
+		if hasCount {
+			if count, err = strconv.Atoi(countText); err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, gocondense.LineRange{Start: start, End: start + max(count, 1) - 1})
+	}
+	return ranges
+}
 
-	if flags.NArg() == 0 {
-		return formatStdin(formatter, stdin, stdout, stderr)
+// parseStaged parses the NUL-separated output of git diff --name-only -z,
+// keeping only Go files.
+func parseStaged(out []byte) []string {
+	var files []string
+	for name := range strings.SplitSeq(string(out), "\x00") {
+		if strings.HasSuffix(name, ".go") {
+			files = append(files, filepath.FromSlash(name))
+		}
 	}
-	return processArgs(formatter, flags.Args(), stderr)
+	return files
+}
+
+// readFileList reads the Go files listed in name, or in stdin if name is "-".
+// Files that don't exist are skipped, so lists of changed files may include
+// deleted ones.
+func readFileList(name string, stdin io.Reader) ([]string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if name == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(parseFileList(data), func(name string) bool {
+		_, err := os.Stat(name)
+		return errors.Is(err, fs.ErrNotExist)
+	}), nil
+}
+
+// parseFileList parses a newline-separated list of files, keeping only Go
+// files.
+func parseFileList(data []byte) []string {
+	var files []string
+	for line := range strings.Lines(string(data)) {
+		if name := strings.TrimSpace(line); strings.HasSuffix(name, ".go") {
+			files = append(files, filepath.FromSlash(name))
+		}
+	}
+	return files
 }
 
 // formatStdin reads Go source from stdin, formats it, and writes to stdout.
-func formatStdin(formatter *gocondense.Formatter, stdin io.Reader, stdout, stderr io.Writer) int {
+// If verbose is set, each condensed construct is printed to stderr. If
+// reportJSON is set, a JSON report is written instead of the source.
+func formatStdin(formatter *gocondense.Formatter, stdin io.Reader, stdout, stderr io.Writer, verbose, reportJSON bool) int {
 	input, err := io.ReadAll(stdin)
 	if err != nil {
 		fmt.Fprintf(stderr, "Error reading stdin: %v\n", err)
 		return 2
 	}
 
+	src, err := formatter.Normalize(input)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error parsing stdin: %v\n", err)
+		return 2
+	}
+
 	fset := token.NewFileSet()
-	file, sourceAdj, indentAdj, err := parse(fset, "<standard input>", input, true)
+	file, sourceAdj, indentAdj, err := parse(fset, "<standard input>", src, true)
 	if err != nil {
 		fmt.Fprintf(stderr, "Error parsing stdin: %v\n", err)
 		return 2
 	}
 
-	formatter.File(fset, file)
+	changes := formatter.FileChanges(fset, file)
+	if verbose {
+		printChanges(stderr, "<standard input>", changes)
+	}
 
 	if sourceAdj == nil {
 		// Complete file (not fragment) - sort imports as a final step.
 		ast.SortImports(fset, file)
 	}
 
-	output, err := format(fset, file, sourceAdj, indentAdj, input, printCfg)
+	output, err := format(fset, file, sourceAdj, indentAdj, src, printCfg)
 	if err != nil {
 		fmt.Fprintf(stderr, "Error formatting stdin: %v\n", err)
 		return 2
 	}
+	output = formatter.FinalNewline(input, output)
+
+	if reportJSON {
+		if err := writeReports(stdout, []report{newReport("<standard input>", input, output, changes)}); err != nil {
+			fmt.Fprintf(stderr, "Error writing stdout: %v\n", err)
+			return 2
+		}
+		return 0
+	}
 
 	if _, err := stdout.Write(output); err != nil {
 		fmt.Fprintf(stderr, "Error writing stdout: %v\n", err)
@@ -120,104 +526,314 @@ func formatStdin(formatter *gocondense.Formatter, stdin io.Reader, stdout, stder
 	return 0
 }
 
+// processor formats file and directory arguments.
+type processor struct {
+	formatter        *gocondense.Formatter
+	testFormatter    *gocondense.Formatter // formatter for _test.go files
+	stdout           io.Writer
+	stderr           io.Writer
+	processGenerated bool      // don't skip generated files in directory walks
+	generatedMarkers []string  // comment text marking non-standard generated files
+	staged           bool      // arguments are staged files that need re-staging
+	listed           bool      // arguments were listed rather than given
+	diffBase         string    // git ref to condense changed lines since, if set
+	since            time.Time // skip files last modified before this, if set
+	verbose          bool      // print each condensed construct
+	check            bool      // list files that need condensing instead of writing
+	exitZero         bool      // exit 0 when checked files need condensing
+	exitOnChange     bool      // exit 1 when files were written
+	failFast         bool      // stop starting files after the first error
+
+	mu          sync.Mutex
+	reports     []report // non-nil when reporting instead of writing files
+	written     []string // files written
+	unformatted []string // files that need condensing when checking
+}
+
+// report summarises the condensable constructs in a file.
+type report struct {
+	Filename string         `json:"filename"`
+	Changed  bool           `json:"changed"`
+	Counts   map[string]int `json:"counts"`
+}
+
+// newReport returns the report for a file formatted from input to output.
+func newReport(filename string, input, output []byte, changes []gocondense.Change) report {
+	r := report{Filename: filename, Changed: !bytes.Equal(input, output), Counts: map[string]int{}}
+	for _, c := range changes {
+		r.Counts[c.Feature.String()]++
+	}
+	return r
+}
+
+// writeReports writes reports to w as an indented JSON array.
+func writeReports(w io.Writer, reports []report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(reports)
+}
+
 // processArgs formats the given file and directory arguments concurrently.
-func processArgs(formatter *gocondense.Formatter, args []string, stderr io.Writer) int {
+// If more than one error occurs, their number is printed once all files are
+// processed.
+func (p *processor) processArgs(args []string) int {
 	var (
-		wg        sync.WaitGroup
-		hasErrors atomic.Bool
-		sem       = semaphore.NewWeighted(int64(runtime.NumCPU()))
+		wg       sync.WaitGroup
+		failures atomic.Int64
+		sem      = semaphore.NewWeighted(int64(runtime.NumCPU()))
 	)
+	stopped := func() bool { return p.failFast && failures.Load() > 0 }
 
 	for _, arg := range args {
-		root, recursive := strings.CutSuffix(arg, "/...")
+		if stopped() {
+			break
+		}
+		root, recursive := cutRecursive(arg)
 		if recursive && root == "" {
 			root = "/" // Avoid empty root when arg is "/..."
 		}
 		root = filepath.Clean(root)
 		info, err := os.Stat(root)
 		if err != nil {
-			fmt.Fprintf(stderr, "Error stating path %s: %v\n", root, err)
-			hasErrors.Store(true)
+			fmt.Fprintf(p.stderr, "Error stating path %s: %v\n", root, err)
+			failures.Add(1)
 			continue
 		}
 
-		// Skip generated files automatically for directory walks.
-		skipGenerated := info.IsDir()
+		// Skip generated files automatically for directory walks and listed files.
+		skipGenerated := (info.IsDir() || p.listed) && !p.processGenerated
 
-		err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 			switch {
 			case err != nil:
 				return err
+			case stopped():
+				return filepath.SkipAll
 			case d.IsDir():
-				if p != root && (!recursive || shouldIgnore(p)) {
+				if path != root && (!recursive || shouldIgnore(path)) {
 					return filepath.SkipDir
 				}
-			case p == root, strings.HasSuffix(d.Name(), ".go") && !strings.HasPrefix(d.Name(), "."):
+			case path == root, strings.HasSuffix(d.Name(), ".go") && !strings.HasPrefix(d.Name(), "."):
+				if ok, err := p.modifiedSince(d); err != nil || !ok {
+					return err
+				}
 				_ = sem.Acquire(context.Background(), 1)
 				wg.Add(1)
 				go func() {
 					defer sem.Release(1)
 					defer wg.Done()
-					if !processFile(formatter, p, skipGenerated, stderr) {
-						hasErrors.Store(true)
+					if !stopped() && !p.processFile(path, skipGenerated) {
+						failures.Add(1)
 					}
 				}()
 			}
 			return nil
 		})
 		if err != nil {
-			fmt.Fprintf(stderr, "Error reading path %s: %v\n", root, err)
-			hasErrors.Store(true)
+			fmt.Fprintf(p.stderr, "Error reading path %s: %v\n", root, err)
+			failures.Add(1)
 		}
 	}
 	wg.Wait()
-	if hasErrors.Load() {
+
+	if p.reports != nil {
+		slices.SortFunc(p.reports, func(a, b report) int { return strings.Compare(a.Filename, b.Filename) })
+		if err := writeReports(p.stdout, p.reports); err != nil {
+			fmt.Fprintf(p.stderr, "Error writing stdout: %v\n", err)
+			failures.Add(1)
+		}
+	}
+
+	if len(p.unformatted) > 0 {
+		slices.Sort(p.unformatted)
+		for _, name := range p.unformatted {
+			fmt.Fprintln(p.stdout, name)
+		}
+	}
+
+	if n := failures.Load(); n > 1 {
+		fmt.Fprintf(p.stderr, "%d errors\n", n)
+	}
+
+	switch {
+	case failures.Load() > 0:
 		return 2
+	case len(p.unformatted) > 0 && !p.exitZero, len(p.written) > 0 && p.exitOnChange:
+		return 1
 	}
 	return 0
 }
 
-// processFile reads, formats, and writes back a single Go file.
-func processFile(formatter *gocondense.Formatter, filename string, skipGenerated bool, stderr io.Writer) bool {
+// cutRecursive returns arg without the /... suffix that requests a recursive
+// walk, and whether it was found. The OS path separator is accepted too, so
+// dir\... works on Windows.
+func cutRecursive(arg string) (string, bool) {
+	if root, ok := strings.CutSuffix(arg, "/..."); ok {
+		return root, true
+	}
+	return strings.CutSuffix(arg, string(filepath.Separator)+"...")
+}
+
+// isGenerated reports whether file has the standard generated code comment, or
+// one of the custom generated markers in a comment before the package clause.
+func (p *processor) isGenerated(file *ast.File) bool {
+	if ast.IsGenerated(file) {
+		return true
+	}
+	for _, group := range file.Comments {
+		if group.Pos() >= file.Package {
+			break
+		}
+		for _, c := range group.List {
+			if slices.ContainsFunc(p.generatedMarkers, func(marker string) bool { return strings.Contains(c.Text, marker) }) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// modifiedSince reports whether the file was modified at or after the since
+// time, or true if it is unset.
+func (p *processor) modifiedSince(d fs.DirEntry) (bool, error) {
+	if p.since.IsZero() {
+		return true, nil
+	}
+	info, err := d.Info()
+	if err != nil {
+		return false, err
+	}
+	return !info.ModTime().Before(p.since), nil
+}
+
+// parseSince parses a duration before now, e.g. "24h", or a timestamp in
+// RFC 3339, "2006-01-02 15:04:05" or "2006-01-02" format in local time.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("negative duration %q", s)
+		}
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateTime, time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid duration or timestamp %q", s)
+}
+
+// processFile reads, formats, and writes back a single Go file, using the
+// test formatter for _test.go files. When reporting, the result is recorded
+// instead of written.
+func (p *processor) processFile(filename string, skipGenerated bool) bool {
+	formatter := formatterFor(filename, p.formatter, p.testFormatter)
+
 	input, err := os.ReadFile(filename)
 	if err != nil {
-		fmt.Fprintf(stderr, "Error reading file %s: %v\n", filename, err)
+		fmt.Fprintf(p.stderr, "Error reading file %s: %v\n", filename, err)
 		return false
 	}
 
+	src, err := formatter.Normalize(input)
+	if err != nil {
+		fmt.Fprintf(p.stderr, "Error parsing file %s: %v\n", filename, err)
+		return false
+	}
+
+	if p.diffBase != "" && !bytes.Equal(src, input) {
+		// The changed lines refer to the file as written, and normalizing may
+		// add or remove lines, so condense the file as written instead.
+		fmt.Fprintf(p.stderr, "Not normalizing file %s: diff-base lines refer to the file as written\n", filename)
+		src = input
+	}
+
 	fset := token.NewFileSet()
-	file, err := parser.ParseFile(fset, filename, input, parserMode)
+	file, err := parser.ParseFile(fset, filename, src, parserMode)
 	if err != nil {
-		fmt.Fprintf(stderr, "Error parsing file %s: %v\n", filename, err)
+		fmt.Fprintf(p.stderr, "Error parsing file %s: %v\n", filename, err)
 		return false
 	}
 
-	if skipGenerated && ast.IsGenerated(file) {
+	if skipGenerated && p.isGenerated(file) {
 		return true
 	}
 
-	formatter.File(fset, file)
+	var changes []gocondense.Change
+	if p.diffBase != "" {
+		lines, err := changedLines(p.diffBase, filename)
+		if err != nil {
+			fmt.Fprintf(p.stderr, "Error diffing file %s: %v\n", filename, err)
+			return false
+		}
+		if len(lines) == 0 {
+			return true
+		}
+		changes = formatter.FileLines(fset, file, lines)
+	} else {
+		changes = formatter.FileChanges(fset, file)
+	}
+	if p.verbose {
+		p.mu.Lock()
+		printChanges(p.stderr, filename, changes)
+		p.mu.Unlock()
+	}
 
 	var buf bytes.Buffer
 	if err := goformat.Node(&buf, fset, file); err != nil {
-		fmt.Fprintf(stderr, "Error formatting file %s: %v\n", filename, err)
+		fmt.Fprintf(p.stderr, "Error formatting file %s: %v\n", filename, err)
 		return false
 	}
-	output := buf.Bytes()
+	output := formatter.FinalNewline(input, buf.Bytes())
+
+	if p.reports != nil {
+		p.mu.Lock()
+		p.reports = append(p.reports, newReport(filename, input, output, changes))
+		p.mu.Unlock()
+		return true
+	}
 
 	if bytes.Equal(input, output) {
 		return true
 	}
 
+	if p.check {
+		p.mu.Lock()
+		p.unformatted = append(p.unformatted, filename)
+		p.mu.Unlock()
+		return true
+	}
+
 	err = os.WriteFile(filename, output, 0o600)
 	if err != nil {
-		fmt.Fprintf(stderr, "Error writing file %s: %v\n", filename, err)
+		fmt.Fprintf(p.stderr, "Error writing file %s: %v\n", filename, err)
 		return false
 	}
 
+	p.mu.Lock()
+	p.written = append(p.written, filename)
+	p.mu.Unlock()
+
 	return true
 }
 
+// formatterFor returns testFormatter for _test.go files and formatter for
+// all other files.
+func formatterFor(filename string, formatter, testFormatter *gocondense.Formatter) *gocondense.Formatter {
+	if strings.HasSuffix(filename, "_test.go") {
+		return testFormatter
+	}
+	return formatter
+}
+
+// printChanges prints one line per change, e.g. "foo.go:12-15 calls 64->59".
+func printChanges(w io.Writer, filename string, changes []gocondense.Change) {
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s:%d-%d %s %d->%d\n", filename, c.Line, c.EndLine, c.Feature, c.Before, c.After)
+	}
+}
+
 // shouldIgnore reports whether dir should be skipped.
 func shouldIgnore(dir string) bool {
 	switch filepath.Base(dir) {
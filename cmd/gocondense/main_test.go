@@ -9,11 +9,18 @@ import (
 	"go/printer"
 	"go/token"
 	"io"
+	"math/bits"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 	"testing/iotest"
+	"time"
+
+	"github.com/abemedia/gocondense"
 )
 
 const uncondensed = `package main
@@ -57,6 +64,7 @@ func TestRun(t *testing.T) {
 		stdin      io.Reader
 		stdout     io.Writer
 		files      map[string]string
+		git        func(args ...string) ([]byte, error)
 		wantCode   int
 		wantStdout string
 		wantFiles  map[string]string
@@ -86,12 +94,350 @@ func TestRun(t *testing.T) {
 			stdin:      strings.NewReader(uncondensed),
 			wantStdout: uncondensed,
 		},
+		{
+			name:       "min_lines_saved_prevents_condensing",
+			args:       []string{"-min-lines-saved=5"},
+			stdin:      strings.NewReader(uncondensed),
+			wantStdout: uncondensed,
+		},
+		{
+			name:       "max_condense_items_prevents_condensing",
+			args:       []string{"-max-condense-items=2"},
+			stdin:      strings.NewReader(uncondensed),
+			wantStdout: uncondensed,
+		},
+		{
+			name:       "max_nest_depth_prevents_condensing",
+			args:       []string{"-max-nest-depth=1"},
+			stdin:      strings.NewReader("package main\n\nvar _ = f(\n\tg(),\n)\n"),
+			wantStdout: "package main\n\nvar _ = f(\n\tg(),\n)\n",
+		},
+		{
+			name:       "exclude_name",
+			args:       []string{"-exclude-name=keep"},
+			stdin:      strings.NewReader("package main\n\nfunc keep(\n\ta int,\n) {}\n\nfunc other(\n\ta int,\n) {}\n"),
+			wantStdout: "package main\n\nfunc keep(\n\ta int,\n) {\n}\n\nfunc other(a int) {}\n",
+		},
+		{
+			name:       "always_condense_call",
+			args:       []string{"-disable=calls", "-always-condense-call=errors.New"},
+			stdin:      strings.NewReader("package main\n\nvar _ = errors.New(\n\t\"failed\",\n)\n\nvar _ = f(\n\ta,\n)\n"),
+			wantStdout: "package main\n\nvar _ = errors.New(\"failed\")\n\nvar _ = f(\n\ta,\n)\n",
+		},
+		{
+			name:       "max_len_excludes_indent",
+			args:       []string{"-max-len=37", "-max-len-excludes-indent"},
+			stdin:      strings.NewReader("package main\n\nfunc f() {\n\tif x {\n\t\t_ = f(\n\t\t\t\"aaaaaaaaaa\",\n\t\t\t\"bbbbbbbbbb\",\n\t\t)\n\t}\n}\n"),
+			wantStdout: "package main\n\nfunc f() {\n\tif x {\n\t\t_ = f(\"aaaaaaaaaa\", \"bbbbbbbbbb\")\n\t}\n}\n",
+		},
+		{
+			name:       "max_changes_per_file",
+			args:       []string{"-max-changes-per-file=1"},
+			stdin:      strings.NewReader("package main\n\nvar a = f(\n\t1,\n)\n\nvar b = f(\n\t2,\n)\n"),
+			wantStdout: "package main\n\nvar a = f(1)\n\nvar b = f(\n\t2,\n)\n",
+		},
+		{
+			name:       "normalize",
+			args:       []string{"-normalize"},
+			stdin:      strings.NewReader("package main\n\nvar x =" + strings.Repeat(" ", 40) + "f(\n\t\"aaaaaaaaaaaaaaaa\",\n\t\"bbbbbbbbbbbbbbbb\",\n)\n"),
+			wantStdout: "package main\n\nvar x = f(\"aaaaaaaaaaaaaaaa\", \"bbbbbbbbbbbbbbbb\")\n",
+		},
+		{
+			name:       "normalize_syntax_error",
+			args:       []string{"-normalize"},
+			stdin:      strings.NewReader("package main\n\nfunc {"),
+			wantCode:   2,
+			wantStderr: "Error parsing stdin:",
+		},
+		{
+			name:       "verbose_stdin",
+			args:       []string{"-verbose"},
+			stdin:      strings.NewReader(uncondensed),
+			wantStdout: condensed,
+			wantStderr: "<standard input>:6-10 calls 51->41\n",
+		},
+		{
+			name:       "verbose_file",
+			args:       []string{"-verbose", "main.go"},
+			files:      map[string]string{"main.go": uncondensed},
+			wantFiles:  map[string]string{"main.go": condensed},
+			wantStderr: "main.go:6-10 calls 51->41\n",
+		},
+		{
+			name:       "preserve_iota_blocks",
+			args:       []string{"-preserve-iota-blocks"},
+			stdin:      strings.NewReader("package main\n\nconst (\n\tA = iota\n)\n"),
+			wantStdout: "package main\n\nconst (\n\tA = iota\n)\n",
+		},
+		{
+			name:       "final_newline_never",
+			args:       []string{"-final-newline=never"},
+			stdin:      strings.NewReader(uncondensed),
+			wantStdout: strings.TrimSuffix(condensed, "\n"),
+		},
+		{
+			name:       "strict_comments",
+			args:       []string{"-strict-comments"},
+			stdin:      strings.NewReader("package main\n\nvar (\n\tx = 1 // comment\n)\n"),
+			wantStdout: "package main\n\nvar (\n\tx = 1 // comment\n)\n",
+		},
+		{
+			name:       "disable_feature",
+			args:       []string{"-disable=calls"},
+			stdin:      strings.NewReader(uncondensed),
+			wantStdout: uncondensed,
+		},
+		{
+			name:       "enable_feature",
+			args:       []string{"-enable=calls"},
+			stdin:      strings.NewReader(uncondensed),
+			wantStdout: condensed,
+		},
+		{
+			name:       "unknown_feature",
+			args:       []string{"-enable=unknown"},
+			wantCode:   2,
+			wantStderr: `invalid value "unknown" for flag -enable: unknown feature "unknown"`,
+		},
+		{
+			name:       "invalid_since",
+			args:       []string{"-since=yesterday"},
+			wantCode:   2,
+			wantStderr: `invalid value "yesterday" for flag -since: invalid duration or timestamp "yesterday"`,
+		},
+		{
+			name:       "no_features",
+			args:       []string{"-enable=calls", "-disable=calls"},
+			wantCode:   2,
+			wantStderr: "no features enabled",
+		},
+		{
+			name:       "unsupported_format",
+			args:       []string{"-format=xml"},
+			wantCode:   2,
+			wantStderr: "unsupported format \"xml\"",
+		},
+		{
+			name:       "serve_with_args",
+			args:       []string{"-serve", "a.go"},
+			wantCode:   2,
+			wantStderr: "serve cannot be used with file arguments",
+		},
+		{
+			name: "print_config",
+			args: []string{"-print-config", "-max-len=100", "-disable=maps,arrays", "-exclude-name=table", "-final-newline=keep"},
+			wantStdout: `{
+  "MaxLen": 100,
+  "MaxLenExcludesIndent": false,
+  "TabWidth": 4,
+  "Features": "declarations,types,type-params,params,results,literals,calls,structs,slices,expressions,switches,imports",
+  "MinLinesSaved": 0,
+  "MaxCondenseItems": 0,
+  "MaxNestDepth": 0,
+  "MaxChangesPerFile": 0,
+  "Exclude": [
+    "table"
+  ],
+  "AlwaysCondenseCalls": null,
+  "PreserveIotaBlocks": false,
+  "StrictComments": false,
+  "Normalize": false,
+  "FinalNewline": "keep"
+}
+`,
+		},
+		{
+			name: "check",
+			args: []string{"-check", "."},
+			files: map[string]string{
+				"b.go": uncondensed,
+				"a.go": uncondensed,
+				"c.go": condensed,
+			},
+			wantCode:   1,
+			wantStdout: "a.go\nb.go\n",
+			wantFiles: map[string]string{
+				"a.go": uncondensed,
+				"b.go": uncondensed,
+				"c.go": condensed,
+			},
+		},
+		{
+			name:  "check_formatted",
+			args:  []string{"-check", "c.go"},
+			files: map[string]string{"c.go": condensed},
+		},
+		{
+			name:       "check_exit_zero",
+			args:       []string{"-check", "-exit-zero", "a.go"},
+			files:      map[string]string{"a.go": uncondensed},
+			wantStdout: "a.go\n",
+			wantFiles:  map[string]string{"a.go": uncondensed},
+		},
+		{
+			name:       "check_exit_zero_with_error",
+			args:       []string{"-check", "-exit-zero", "a.go", "missing.go"},
+			files:      map[string]string{"a.go": uncondensed},
+			wantCode:   2,
+			wantStdout: "a.go\n",
+			wantStderr: "Error stating path missing.go",
+		},
+		{
+			name:       "check_with_parse_error",
+			args:       []string{"-check", "."},
+			files:      map[string]string{"a.go": uncondensed, "bad.go": "not valid go"},
+			wantCode:   2,
+			wantStdout: "a.go\n",
+			wantStderr: "Error parsing file bad.go:",
+		},
+		{
+			name:      "exit_code_on_change",
+			args:      []string{"-exit-code-on-change", "a.go", "c.go"},
+			files:     map[string]string{"a.go": uncondensed, "c.go": condensed},
+			wantCode:  1,
+			wantFiles: map[string]string{"a.go": condensed, "c.go": condensed},
+		},
+		{
+			name:  "exit_code_on_change_unchanged",
+			args:  []string{"-exit-code-on-change", "c.go"},
+			files: map[string]string{"c.go": condensed},
+		},
+		{
+			name:       "exit_code_on_change_with_error",
+			args:       []string{"-exit-code-on-change", "a.go", "missing.go"},
+			files:      map[string]string{"a.go": uncondensed},
+			wantCode:   2,
+			wantFiles:  map[string]string{"a.go": condensed},
+			wantStderr: "Error stating path missing.go",
+		},
+		{
+			name:       "check_stdin",
+			args:       []string{"-check"},
+			wantCode:   2,
+			wantStderr: "check requires file arguments",
+		},
+		{
+			name:       "check_with_format",
+			args:       []string{"-check", "-format=json", "a.go"},
+			wantCode:   2,
+			wantStderr: "check cannot be used with format",
+		},
+		{
+			name:       "files_from_with_args",
+			args:       []string{"-files-from=-", "a.go"},
+			wantCode:   2,
+			wantStderr: "files-from cannot be used with file arguments",
+		},
+		{
+			name:       "files_from_missing_list",
+			args:       []string{"-files-from=missing.txt"},
+			wantCode:   2,
+			wantStderr: "Error reading file list:",
+		},
+		{
+			name:  "files_from_stdin",
+			args:  []string{"-files-from=-"},
+			stdin: strings.NewReader("a.go\n\n  sub/b.go  \nnot_go.txt\ndeleted.go\n"),
+			files: map[string]string{
+				"a.go":       uncondensed,
+				"sub/b.go":   uncondensed,
+				"c.go":       uncondensed,
+				"not_go.txt": uncondensed,
+			},
+			wantFiles: map[string]string{
+				"a.go":       condensed,
+				"sub/b.go":   condensed,
+				"c.go":       uncondensed,
+				"not_go.txt": uncondensed,
+			},
+		},
+		{
+			name: "files_from_file",
+			args: []string{"-files-from=list.txt"},
+			files: map[string]string{
+				"list.txt": "a.go\r\n",
+				"a.go":     uncondensed,
+			},
+			wantFiles: map[string]string{
+				"a.go": condensed,
+			},
+		},
+		{
+			name: "diff_base_skips_generated",
+			args: []string{"-diff-base=HEAD"},
+			files: map[string]string{
+				"a.go":   uncondensed,
+				"gen.go": generated,
+			},
+			git: func(args ...string) ([]byte, error) {
+				if slices.Contains(args, "--name-only") {
+					return []byte("a.go\x00gen.go\x00"), nil
+				}
+				return []byte("@@ -1,11 +1,11 @@\n"), nil
+			},
+			wantFiles: map[string]string{
+				"a.go":   condensed,
+				"gen.go": generated,
+			},
+		},
+		{
+			name: "diff_base_process_generated",
+			args: []string{"-diff-base=HEAD", "-process-generated"},
+			files: map[string]string{
+				"gen.go": generated,
+			},
+			git: func(args ...string) ([]byte, error) {
+				if slices.Contains(args, "--name-only") {
+					return []byte("gen.go\x00"), nil
+				}
+				return []byte("@@ -1,13 +1,13 @@\n"), nil
+			},
+			wantFiles: map[string]string{
+				"gen.go": generatedCondensed,
+			},
+		},
+		{
+			name:  "files_from_skips_generated",
+			args:  []string{"-files-from=-"},
+			stdin: strings.NewReader("a.go\ngen.go\n"),
+			files: map[string]string{
+				"a.go":   uncondensed,
+				"gen.go": generated,
+			},
+			wantFiles: map[string]string{
+				"a.go":   condensed,
+				"gen.go": generated,
+			},
+		},
+		{
+			name:  "files_from_process_generated",
+			args:  []string{"-files-from=-", "-process-generated"},
+			stdin: strings.NewReader("gen.go\n"),
+			files: map[string]string{
+				"gen.go": generated,
+			},
+			wantFiles: map[string]string{
+				"gen.go": generatedCondensed,
+			},
+		},
+		{
+			name:       "staged_with_args",
+			args:       []string{"-staged", "a.go"},
+			wantCode:   2,
+			wantStderr: "staged cannot be used with file arguments",
+		},
 		{
 			name:       "unknown_flag",
 			args:       []string{"-unknown"},
 			wantCode:   2,
 			wantStderr: "flag provided but not defined: -unknown",
 		},
+		{
+			name:       "max_len_zero_condenses_regardless_of_length",
+			args:       []string{"-max-len=0"},
+			stdin:      strings.NewReader("package main\n\nvar _ = f(\n\t\"" + strings.Repeat("a", 300) + "\",\n)\n"),
+			wantStdout: "package main\n\nvar _ = f(\"" + strings.Repeat("a", 300) + "\")\n",
+		},
 		{
 			name:       "negative_max_len",
 			args:       []string{"-max-len=-1"},
@@ -104,6 +450,18 @@ func TestRun(t *testing.T) {
 			wantCode:   2,
 			wantStderr: "max-len and tab-width must not be negative",
 		},
+		{
+			name:       "negative_max_condense_items",
+			args:       []string{"-max-condense-items=-1"},
+			wantCode:   2,
+			wantStderr: "max-condense-items and test-max-condense-items must not be negative",
+		},
+		{
+			name:       "negative_test_max_condense_items",
+			args:       []string{"-test-max-condense-items=-1"},
+			wantCode:   2,
+			wantStderr: "max-condense-items and test-max-condense-items must not be negative",
+		},
 		// Stdin
 		{
 			name:       "formats_stdin",
@@ -170,6 +528,112 @@ func TestRun(t *testing.T) {
 			wantCode:   2,
 			wantStderr: "Error stating path nonexistent.go:",
 		},
+		{
+			name: "test_disable",
+			args: []string{"-test-disable=calls", "."},
+			files: map[string]string{
+				"main.go":      uncondensed,
+				"main_test.go": uncondensed,
+			},
+			wantFiles: map[string]string{
+				"main.go":      condensed,
+				"main_test.go": uncondensed,
+			},
+		},
+		{
+			name: "test_max_condense_items",
+			args: []string{"-test-max-condense-items=2", "."},
+			files: map[string]string{
+				"main.go":      uncondensed,
+				"main_test.go": uncondensed,
+			},
+			wantFiles: map[string]string{
+				"main.go":      condensed,
+				"main_test.go": uncondensed,
+			},
+		},
+		{
+			name: "test_max_condense_items_overrides_max_condense_items",
+			args: []string{"-max-condense-items=2", "-test-max-condense-items=0", "."},
+			files: map[string]string{
+				"main.go":      uncondensed,
+				"main_test.go": uncondensed,
+			},
+			wantFiles: map[string]string{
+				"main.go":      uncondensed,
+				"main_test.go": condensed,
+			},
+		},
+		{
+			name:       "disable_imports",
+			args:       []string{"-disable=imports"},
+			stdin:      strings.NewReader("package main\n\nimport (\n\t\"fmt\"\n)\n\nvar (\n\tx = fmt.Sprint(1)\n)\n"),
+			wantStdout: "package main\n\nimport (\n\t\"fmt\"\n)\n\nvar x = fmt.Sprint(1)\n",
+		},
+		{
+			name:       "test_disable_all",
+			args:       []string{"-test-disable=all", "."},
+			wantCode:   2,
+			wantStderr: "no features enabled for test files",
+		},
+		{
+			name:       "mixed_success_and_failure",
+			args:       []string{"bad.go", "a.go"},
+			files:      map[string]string{"bad.go": "not valid go", "a.go": uncondensed},
+			wantCode:   2,
+			wantFiles:  map[string]string{"a.go": condensed},
+			wantStderr: "Error parsing file bad.go:",
+		},
+		{
+			name:       "fail_fast",
+			args:       []string{"-fail-fast", "missing.go", "a.go"},
+			files:      map[string]string{"a.go": uncondensed},
+			wantCode:   2,
+			wantFiles:  map[string]string{"a.go": uncondensed},
+			wantStderr: "Error stating path missing.go:",
+		},
+		{
+			name: "format_json",
+			args: []string{"-format=json", "."},
+			files: map[string]string{
+				"a.go": uncondensed,
+				"b.go": condensed,
+			},
+			wantStdout: `[
+  {
+    "filename": "a.go",
+    "changed": true,
+    "counts": {
+      "calls": 1
+    }
+  },
+  {
+    "filename": "b.go",
+    "changed": false,
+    "counts": {}
+  }
+]
+`,
+			wantFiles: map[string]string{
+				"a.go": uncondensed,
+				"b.go": condensed,
+			},
+		},
+		{
+			name:  "format_json_stdin",
+			args:  []string{"-format=json"},
+			stdin: strings.NewReader(uncondensed),
+			wantStdout: `[
+  {
+    "filename": "<standard input>",
+    "changed": true,
+    "counts": {
+      "calls": 1
+    }
+  }
+]
+`,
+		},
 		// Directories
 		{
 			name: "directory_non_recursive",
@@ -193,6 +657,84 @@ func TestRun(t *testing.T) {
 				"sub/sub.go": condensed,
 			},
 		},
+		{
+			name: "single_file_in_directory",
+			args: []string{"sub/a.go"},
+			files: map[string]string{
+				"sub/a.go":      uncondensed,
+				"sub/b.go":      uncondensed,
+				"sub/deep/c.go": uncondensed,
+			},
+			wantFiles: map[string]string{
+				"sub/a.go":      condensed,
+				"sub/b.go":      uncondensed,
+				"sub/deep/c.go": uncondensed,
+			},
+		},
+		{
+			name: "subdirectory_non_recursive",
+			args: []string{"sub/"},
+			files: map[string]string{
+				"top.go":        uncondensed,
+				"sub/a.go":      uncondensed,
+				"sub/deep/c.go": uncondensed,
+			},
+			wantFiles: map[string]string{
+				"top.go":        uncondensed,
+				"sub/a.go":      condensed,
+				"sub/deep/c.go": uncondensed,
+			},
+		},
+		{
+			name: "subdirectory_recursive_os_separator",
+			args: []string{filepath.FromSlash("sub/...")},
+			files: map[string]string{
+				"top.go":        uncondensed,
+				"sub/a.go":      uncondensed,
+				"sub/deep/c.go": uncondensed,
+			},
+			wantFiles: map[string]string{
+				"top.go":        uncondensed,
+				"sub/a.go":      condensed,
+				"sub/deep/c.go": condensed,
+			},
+		},
+		{
+			name: "subdirectory_recursive",
+			args: []string{"sub/..."},
+			files: map[string]string{
+				"top.go":        uncondensed,
+				"sub/a.go":      uncondensed,
+				"sub/deep/c.go": uncondensed,
+			},
+			wantFiles: map[string]string{
+				"top.go":        uncondensed,
+				"sub/a.go":      condensed,
+				"sub/deep/c.go": condensed,
+			},
+		},
+		{
+			name: "mixed_arguments",
+			args: []string{"a.go", "dir", "tree/...", "./tree/../b.go"},
+			files: map[string]string{
+				"a.go":           uncondensed,
+				"b.go":           uncondensed,
+				"c.go":           uncondensed,
+				"dir/d.go":       uncondensed,
+				"dir/deep/e.go":  uncondensed,
+				"tree/f.go":      uncondensed,
+				"tree/deep/g.go": uncondensed,
+			},
+			wantFiles: map[string]string{
+				"a.go":           condensed,
+				"b.go":           condensed,
+				"c.go":           uncondensed,
+				"dir/d.go":       condensed,
+				"dir/deep/e.go":  uncondensed,
+				"tree/f.go":      condensed,
+				"tree/deep/g.go": condensed,
+			},
+		},
 		// Skipping
 		{
 			name: "skip",
@@ -212,6 +754,56 @@ func TestRun(t *testing.T) {
 				"vendor/v.go":   uncondensed,
 			},
 		},
+		{
+			name: "process_generated",
+			args: []string{"-process-generated", "./..."},
+			files: map[string]string{
+				"generated.go":     generated,
+				"sub/generated.go": generated,
+			},
+			wantFiles: map[string]string{
+				"generated.go":     generatedCondensed,
+				"sub/generated.go": generatedCondensed,
+			},
+		},
+		{
+			name: "generated_marker",
+			args: []string{"-generated-marker=AUTOGENERATED", "-generated-marker=@generated", "./..."},
+			files: map[string]string{
+				"banner.go":    "// AUTOGENERATED FILE\n\n" + uncondensed,
+				"sub/block.go": "/* @generated */\n" + uncondensed,
+				"after.go":     strings.Replace(uncondensed, "\n\n", "\n\n// AUTOGENERATED\n", 1),
+				"plain.go":     uncondensed,
+			},
+			wantFiles: map[string]string{
+				"banner.go":    "// AUTOGENERATED FILE\n\n" + uncondensed,
+				"sub/block.go": "/* @generated */\n" + uncondensed,
+				"after.go":     strings.Replace(condensed, "\n\n", "\n\n// AUTOGENERATED\n", 1),
+				"plain.go":     condensed,
+			},
+		},
+		{
+			name: "generated_marker_process_generated",
+			args: []string{"-generated-marker=AUTOGENERATED", "-process-generated", "./..."},
+			files: map[string]string{
+				"banner.go": "// AUTOGENERATED\n\n" + uncondensed,
+			},
+			wantFiles: map[string]string{
+				"banner.go": "// AUTOGENERATED\n\n" + condensed,
+			},
+		},
+		{
+			name:       "empty_generated_marker",
+			args:       []string{"-generated-marker="},
+			wantCode:   2,
+			wantStderr: `invalid value "" for flag -generated-marker: empty marker`,
+		},
+		{
+			name:       "empty_exclude_name",
+			args:       []string{"-exclude-name="},
+			wantCode:   2,
+			wantStderr: `invalid value "" for flag -exclude-name: empty name`,
+		},
 		{
 			name: "bypass_skip",
 			args: []string{"generated.go", "not_go.txt", "vendor", "testdata", "tools"},
@@ -275,6 +867,11 @@ func TestRun(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Chdir(t.TempDir())
 			clear(modIgnoreCache)
+			if tt.git != nil {
+				orig := git
+				t.Cleanup(func() { git = orig })
+				git = tt.git
+			}
 
 			for name, content := range tt.files {
 				if err := os.MkdirAll(path.Dir(name), 0o755); err != nil {
@@ -312,6 +909,27 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestErrorCount(t *testing.T) {
+	t.Chdir(t.TempDir())
+	os.WriteFile("a.go", []byte(uncondensed), 0o644)
+	os.WriteFile("bad1.go", []byte("not valid go"), 0o644)
+	os.WriteFile("bad2.go", []byte("not valid go"), 0o644)
+
+	var stderr bytes.Buffer
+	if code := run([]string{"gocondense", "."}, nil, io.Discard, &stderr); code != 2 {
+		t.Fatalf("exit code = %d, want 2", code)
+	}
+	if got := strings.Count(stderr.String(), "Error parsing file"); got != 2 {
+		t.Errorf("got %d parse errors, want 2: %s", got, stderr.String())
+	}
+	if !strings.HasSuffix(stderr.String(), "\n2 errors\n") {
+		t.Errorf("stderr doesn't end with error count: %q", stderr.String())
+	}
+	if got, _ := os.ReadFile("a.go"); string(got) != condensed {
+		t.Errorf("a.go:\ngot:  %q\nwant: %q", got, condensed)
+	}
+}
+
 func TestPermissions(t *testing.T) {
 	t.Chdir(t.TempDir())
 	os.MkdirAll("noperm", 0o000)
@@ -343,6 +961,443 @@ func TestPermissions(t *testing.T) {
 	}
 }
 
+func TestStaged(t *testing.T) {
+	t.Chdir(t.TempDir())
+	files := map[string]string{
+		"a.go":         uncondensed,
+		"sub/b.go":     uncondensed,
+		"clean.go":     condensed,
+		"generated.go": generated,
+		"unstaged.go":  uncondensed,
+		"partial.go":   uncondensed,
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(path.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var added []string
+	orig := git
+	t.Cleanup(func() { git = orig })
+	git = func(args ...string) ([]byte, error) {
+		switch args[0] {
+		case "diff":
+			if !slices.Contains(args, "--cached") {
+				return []byte("partial.go\x00"), nil
+			}
+			return []byte("a.go\x00sub/b.go\x00clean.go\x00generated.go\x00partial.go\x00"), nil
+		case "add":
+			added = args[2:]
+			return nil, nil
+		}
+		return nil, errTest
+	}
+
+	var stderr bytes.Buffer
+	if code := run([]string{"gocondense", "-staged"}, nil, io.Discard, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, want 0: %s", code, stderr.String())
+	}
+	if want := "Skipping file partial.go: it has unstaged changes\n"; stderr.String() != want {
+		t.Errorf("stderr:\ngot:  %q\nwant: %q", stderr.String(), want)
+	}
+
+	if want := []string{"a.go", filepath.FromSlash("sub/b.go")}; !slices.Equal(added, want) {
+		t.Errorf("added = %q, want %q", added, want)
+	}
+	wantFiles := map[string]string{
+		"a.go":         condensed,
+		"sub/b.go":     condensed,
+		"clean.go":     condensed,
+		"generated.go": generated,
+		"unstaged.go":  uncondensed,
+		"partial.go":   uncondensed,
+	}
+	for name, want := range wantFiles {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s:\ngot:  %q\nwant: %q", name, string(got), want)
+		}
+	}
+}
+
+func TestStagedGitError(t *testing.T) {
+	orig := git
+	t.Cleanup(func() { git = orig })
+	git = func(...string) ([]byte, error) { return nil, errTest }
+
+	var stderr bytes.Buffer
+	if code := run([]string{"gocondense", "-staged"}, nil, io.Discard, &stderr); code != 2 {
+		t.Fatalf("exit code = %d, want 2", code)
+	}
+	if want := "Error listing staged files: test error"; !strings.HasPrefix(stderr.String(), want) {
+		t.Errorf("stderr:\ngot:  %q\nwant: %q", stderr.String(), want)
+	}
+}
+
+func TestDiffBase(t *testing.T) {
+	t.Chdir(t.TempDir())
+	src := "package main\n\nvar a = f(\n\t1,\n)\n\nvar b = g(\n\t2,\n)\n"
+	for _, name := range []string{"a.go", "unchanged.go"} {
+		if err := os.WriteFile(name, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	orig := git
+	t.Cleanup(func() { git = orig })
+	var listed bool
+	git = func(args ...string) ([]byte, error) {
+		switch {
+		case slices.Contains(args, "--name-only"):
+			listed = slices.Contains(args, "origin/main")
+			return []byte("a.go\x00unchanged.go\x00"), nil
+		case args[len(args)-1] == "a.go":
+			return []byte("diff --git a/a.go b/a.go\n@@ -8 +8 @@ var b = g(\n-\t3,\n+\t2,\n"), nil
+		}
+		return nil, nil
+	}
+
+	var stderr bytes.Buffer
+	if code := run([]string{"gocondense", "-diff-base=origin/main"}, nil, io.Discard, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, want 0: %s", code, stderr.String())
+	}
+	if !listed {
+		t.Error("changed files not listed against diff base")
+	}
+
+	wantFiles := map[string]string{
+		"a.go":         "package main\n\nvar a = f(\n\t1,\n)\n\nvar b = g(2)\n",
+		"unchanged.go": src,
+	}
+	for name, want := range wantFiles {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s:\ngot:  %q\nwant: %q", name, string(got), want)
+		}
+	}
+}
+
+func TestDiffBaseNormalize(t *testing.T) {
+	t.Chdir(t.TempDir())
+	// Normalizing collapses the blank lines above b, which would move c onto
+	// the changed line 11.
+	src := "package main\n\nvar a = f(\n\t1,\n)\n\n\n\n\nvar b = g(\n\t2,\n)\n\nvar c = h(\n\t3,\n)\n"
+	if err := os.WriteFile("a.go", []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	orig := git
+	t.Cleanup(func() { git = orig })
+	git = func(args ...string) ([]byte, error) {
+		return []byte("diff --git a/a.go b/a.go\n@@ -11 +11 @@ var b = g(\n-\t3,\n+\t2,\n"), nil
+	}
+
+	var stderr bytes.Buffer
+	if code := run([]string{"gocondense", "-normalize", "-diff-base=origin/main", "a.go"}, nil, io.Discard, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, want 0: %s", code, stderr.String())
+	}
+	if want := "Not normalizing file a.go:"; !strings.HasPrefix(stderr.String(), want) {
+		t.Errorf("stderr:\ngot:  %q\nwant: %q", stderr.String(), want)
+	}
+
+	want := "package main\n\nvar a = f(\n\t1,\n)\n\nvar b = g(2)\n\nvar c = h(\n\t3,\n)\n"
+	got, err := os.ReadFile("a.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("a.go:\ngot:  %q\nwant: %q", string(got), want)
+	}
+}
+
+func TestDiffBaseGitError(t *testing.T) {
+	t.Chdir(t.TempDir())
+	if err := os.WriteFile("a.go", []byte(uncondensed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	orig := git
+	t.Cleanup(func() { git = orig })
+	git = func(...string) ([]byte, error) { return nil, errTest }
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantStderr string
+	}{
+		{"list", []string{"-diff-base=main"}, "Error listing changed files: test error"},
+		{"diff", []string{"-diff-base=main", "a.go"}, "Error diffing file a.go: test error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stderr bytes.Buffer
+			if code := run(append([]string{"gocondense"}, tt.args...), nil, io.Discard, &stderr); code == 0 {
+				t.Fatal("exit code = 0, want non-zero")
+			}
+			if !strings.HasPrefix(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr:\ngot:  %q\nwant: %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
+
+// TestGoGenerate runs the compiled command from a go:generate directive on a
+// file with build constraints and no trailing newline.
+func TestGoGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the command")
+	}
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	bin := filepath.Join(t.TempDir(), "gocondense")
+	if out, err := exec.Command(goCmd, "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("building command: %v\n%s", err, out)
+	}
+
+	dir := t.TempDir()
+	header := "//go:build !ignore\n\n//go:generate " + filepath.ToSlash(bin) + " $GOFILE\n\npackage main\n\n"
+	files := map[string]string{
+		"go.mod":   "module test\n\ngo 1.25\n",
+		"gen.go":   header + "var x = f(\n\t1,\n)",
+		"other.go": "package main\n\nvar y = f(\n\t1,\n)\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(goCmd, "generate", "./...")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go generate: %v\n%s", err, out)
+	}
+
+	want := map[string]string{
+		"gen.go":   header + "var x = f(1)\n",
+		"other.go": files["other.go"], // Only $GOFILE is formatted.
+	}
+	for name, want := range want {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s:\ngot:  %q\nwant: %q", name, got, want)
+		}
+	}
+}
+
+func TestParseDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []gocondense.LineRange
+	}{
+		{"empty", "", nil},
+		{"single_line", "@@ -3 +3 @@\n-a\n+b\n", []gocondense.LineRange{{Start: 3, End: 3}}},
+		{"multiple_lines", "@@ -3,2 +4,3 @@ func f() {\n", []gocondense.LineRange{{Start: 4, End: 6}}},
+		{"deletion", "@@ -5,2 +4,0 @@\n", []gocondense.LineRange{{Start: 4, End: 4}}},
+		{
+			name: "multiple_hunks",
+			out:  "diff --git a/a.go b/a.go\n--- a/a.go\n+++ b/a.go\n@@ -1 +1 @@\n-x\n+y\n@@ -10,0 +11,2 @@\n+z\n+w\n",
+			want: []gocondense.LineRange{{Start: 1, End: 1}, {Start: 11, End: 12}},
+		},
+		{"content_resembling_header", "+@@ -1 +1 @@\n", nil},
+		{"malformed", "@@ -1 +x @@\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseDiffLines([]byte(tt.out)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCutRecursive(t *testing.T) {
+	sep := string(filepath.Separator)
+	tests := []struct {
+		arg           string
+		wantRoot      string
+		wantRecursive bool
+	}{
+		{"dir", "dir", false},
+		{"a.go", "a.go", false},
+		{"./...", ".", true},
+		{"/...", "", true},
+		{"dir/...", "dir", true},
+		{"dir/sub/...", "dir/sub", true},
+		{filepath.FromSlash("dir/sub/..."), filepath.FromSlash("dir/sub"), true},
+		{"dir" + sep + "...", "dir", true},
+		{"." + sep + "...", ".", true},
+		{"dir/...x", "dir/...x", false},
+		{"dir...", "dir...", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			root, recursive := cutRecursive(tt.arg)
+			if root != tt.wantRoot || recursive != tt.wantRecursive {
+				t.Errorf("cutRecursive(%q) = %q, %v; want %q, %v", tt.arg, root, recursive, tt.wantRoot, tt.wantRecursive)
+			}
+		})
+	}
+}
+
+func TestParseFileList(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"files", "a.go\nb.go\n", []string{"a.go", "b.go"}},
+		{"no_trailing_newline", "a.go", []string{"a.go"}},
+		{"blank_lines", "\na.go\n\n\nb.go\n", []string{"a.go", "b.go"}},
+		{"surrounding_space", "  a.go\t\r\n", []string{"a.go"}},
+		{"non_go_files", "a.txt\ngo.mod\nb.go\n", []string{"b.go"}},
+		{"nested", "sub/a.go\n", []string{filepath.FromSlash("sub/a.go")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseFileList([]byte(tt.data)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSince(t *testing.T) {
+	t.Chdir(t.TempDir())
+	old := time.Now().Add(-48 * time.Hour)
+	for _, name := range []string{"new.go", "old.go", "sub/new.go", "sub/old.go"} {
+		if err := os.MkdirAll(path.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(uncondensed), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasSuffix(name, "old.go") {
+			if err := os.Chtimes(name, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var stderr bytes.Buffer
+	if code := run([]string{"gocondense", "-since=24h", "./...", "old.go"}, nil, io.Discard, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, want 0: %s", code, stderr.String())
+	}
+
+	wantFiles := map[string]string{
+		"new.go":     condensed,
+		"old.go":     uncondensed,
+		"sub/new.go": condensed,
+		"sub/old.go": uncondensed,
+	}
+	for name, want := range wantFiles {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s:\ngot:  %q\nwant: %q", name, string(got), want)
+		}
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "24h", want: now.Add(-24 * time.Hour)},
+		{in: "90m", want: now.Add(-90 * time.Minute)},
+		{in: "2024-05-01", want: time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)},
+		{in: "2024-05-01 08:30:00", want: time.Date(2024, 5, 1, 8, 30, 0, 0, time.Local)},
+		{in: "2024-05-01T08:30:00Z", want: time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)},
+		{in: "-1h", wantErr: true},
+		{in: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseSince(tt.in, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr = %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageFeatures(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"gocondense", "-help"}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	_, features, ok := strings.Cut(stderr.String(), "\nFeatures (for -enable and -disable, all enabled by default):\n")
+	if !ok {
+		t.Fatalf("missing features section:\n%s", stderr.String())
+	}
+	features, groups, _ := strings.Cut(features, "\n\n")
+
+	names := strings.Fields(features)
+	if !slices.IsSorted(names) {
+		t.Errorf("features not sorted: %q", names)
+	}
+	if want := bits.OnesCount(uint(gocondense.All)); len(names) != want {
+		t.Errorf("got %d features, want %d: %q", len(names), want, names)
+	}
+	if want := "Groups:\n  all\n  body-literals  calls,structs,slices,maps,arrays\n" +
+		"  funcs          type-params,params,results\n" +
+		"  signatures     declarations,types,type-params,params,results,literals,imports\n"; groups != want {
+		t.Errorf("groups:\ngot:  %q\nwant: %q", groups, want)
+	}
+}
+
+func TestParseStaged(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"go_files", "a.go\x00dir/b.go\x00", []string{"a.go", filepath.FromSlash("dir/b.go")}},
+		{"non_go_files", "README.md\x00a.go\x00go.mod\x00", []string{"a.go"}},
+		{"spaces", "my file.go\x00", []string{"my file.go"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseStaged([]byte(tt.out)); !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 // TestNormalizeNumbers verifies that our hardcoded normalizeNumbers constant
 // matches the stdlib's behavior by formatting a non-canonical number literal
 // and comparing the output with go/format.Source.
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/abemedia/gocondense"
+)
+
+// maxMessageSize is the largest message body accepted in server mode.
+const maxMessageSize = 64 << 20
+
+// request is a formatting request received in server mode. Filename is
+// optional and only selects the formatter, e.g. for _test.go files.
+type request struct {
+	Filename string `json:"filename"`
+	Text     string `json:"text"`
+}
+
+// response is the reply to a [request]. Error is set if formatting failed.
+type response struct {
+	Text  string `json:"text"`
+	Error string `json:"error,omitempty"`
+}
+
+// serve answers formatting requests read from r until it is closed, using
+// testFormatter for requests naming a _test.go file. Messages in both
+// directions are JSON objects preceded by a Content-Length header, as in the
+// Language Server Protocol base protocol.
+func serve(formatter, testFormatter *gocondense.Formatter, r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	for {
+		body, err := readMessage(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		var (
+			req         request
+			resp        response
+			out, errOut bytes.Buffer
+		)
+		switch {
+		case json.Unmarshal(body, &req) != nil:
+			resp.Error = "invalid request"
+		case formatStdin(formatterFor(req.Filename, formatter, testFormatter), strings.NewReader(req.Text), &out, &errOut, false, false) != 0:
+			resp.Error = strings.TrimSpace(errOut.String())
+		default:
+			resp.Text = out.String()
+		}
+
+		if err := writeMessage(w, resp); err != nil {
+			return err
+		}
+	}
+}
+
+// readMessage reads the body of a Content-Length framed message. It returns
+// io.EOF if r is closed before a message starts.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	if n > maxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes exceeds limit of %d bytes", n, maxMessageSize)
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("reading body: %w", err)
+	}
+	return body, nil
+}
+
+// writeMessage writes v as a Content-Length framed JSON message.
+func writeMessage(w io.Writer, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	done := make(chan int)
+	var stderr bytes.Buffer
+	go func() {
+		defer outW.Close()
+		done <- run([]string{"gocondense", "-serve", "-test-disable=calls"}, inR, outW, &stderr)
+	}()
+
+	responses := bufio.NewReader(outR)
+	roundTrip := func(t *testing.T, body string) response {
+		t.Helper()
+		if _, err := fmt.Fprintf(inW, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := readMessage(responses)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp response
+		if err := json.Unmarshal(msg, &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	request := func(filename, text string) string {
+		b, _ := json.Marshal(request{Filename: filename, Text: text})
+		return string(b)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want response
+	}{
+		{"formats", request("", uncondensed), response{Text: condensed}},
+		{"formats_again", request("", uncondensed), response{Text: condensed}},
+		{"filename", request("main.go", uncondensed), response{Text: condensed}},
+		{"test_filename", request("main_test.go", uncondensed), response{Text: uncondensed}},
+		{"fragment", request("", "\t\tvar (\n\t\t\tx = 1\n\t\t)\n"), response{Text: "\t\tvar x = 1\n"}},
+		{"syntax_error", request("", "not valid go"), response{Error: "Error parsing stdin:"}},
+		{"invalid_json", "{", response{Error: "invalid request"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := roundTrip(t, tt.body)
+			if got.Text != tt.want.Text {
+				t.Errorf("text:\ngot:  %q\nwant: %q", got.Text, tt.want.Text)
+			}
+			if !strings.HasPrefix(got.Error, tt.want.Error) || (got.Error == "") != (tt.want.Error == "") {
+				t.Errorf("error:\ngot:  %q\nwant: %q", got.Error, tt.want.Error)
+			}
+		})
+	}
+
+	inW.Close()
+	if code := <-done; code != 0 {
+		t.Fatalf("exit code = %d, want 0: %s", code, stderr.String())
+	}
+}
+
+func TestServeInvalidHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		length     string
+		wantStderr string
+	}{
+		{"not_a_number", "x", `Error serving: invalid Content-Length "x"`},
+		{"negative", "-1", `Error serving: invalid Content-Length "-1"`},
+		{"too_large", "67108865", "Error serving: message of 67108865 bytes exceeds limit of 67108864 bytes"},
+		{"huge", "4611686018427387904", "Error serving: message of 4611686018427387904 bytes exceeds limit of 67108864 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			stdin := strings.NewReader("Content-Length: " + tt.length + "\r\n\r\n{}")
+
+			if code := run([]string{"gocondense", "-serve"}, stdin, &stdout, &stderr); code != 2 {
+				t.Fatalf("exit code = %d, want 2", code)
+			}
+			if !strings.HasPrefix(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr:\ngot:  %q\nwant: %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
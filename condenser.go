@@ -13,14 +13,32 @@ import (
 
 // condenser implements AST traversal callbacks that simplify and condense nodes.
 type condenser struct {
-	maxLen      int
-	tabWidth    int
-	fset        *token.FileSet
-	file        *ast.File
-	tokenFile   *token.File
-	buf         *bytes.Buffer
-	parents     []ast.Node // stack of ancestor nodes for parent-walk
-	indentLevel int        // current nesting depth (blocks, cases)
+	maxLen        int
+	tabWidth      int
+	excludeIndent bool // measure lines without their leading indentation
+	features      Feature
+	minLinesSaved int
+	maxItems      int
+	maxNestDepth  int
+	maxChanges    int
+	exclude       []string // names of declarations to leave as is
+	alwaysCalls   []string // names of functions whose calls are always condensed
+	accept        func(Feature, ast.Node, string) bool
+	preserveIota  bool
+	strict        bool        // any comment within a construct prevents condensing
+	diagnose      bool        // record constructs left as is
+	ranges        []LineRange // original lines to condense, or nil for all
+	fset          *token.FileSet
+	file          *ast.File
+	tokenFile     *token.File
+	buf           *bytes.Buffer
+	parents       []ast.Node // stack of ancestor nodes for parent-walk
+	indentLevel   int        // current nesting depth (blocks, cases)
+	lines         []int      // original line table, for reporting changes
+	changes       []Change   // constructs condensed so far
+	condensed     []ast.Node // node of each change, or nil if left as is
+	modified      bool       // whether the AST was changed
+	applied       int        // number of constructs condensed so far
 }
 
 // applyPre tracks parent nodes and indentation level before visiting children.
@@ -54,12 +72,21 @@ func (e *condenser) applyPost(c *astutil.Cursor) bool { //nolint:cyclop,funlen,g
 
 	switch n := node.(type) {
 	case *ast.GenDecl:
-		if e.simplifyGenDecl(n) {
+		if _, ok := c.Parent().(*ast.DeclStmt); !ok && e.simplifyGenDecl(n) {
 			c.Delete()
+			e.modified = true
+		}
+	case *ast.DeclStmt:
+		// Declarations in function bodies are simplified here, so that an
+		// empty group can be deleted along with its statement.
+		if decl, ok := n.Decl.(*ast.GenDecl); ok && e.simplifyGenDecl(decl) && c.Index() >= 0 {
+			c.Delete()
+			e.modified = true
 		}
 	case *ast.ParenExpr:
 		if e.canRemoveParens(n) {
 			c.Replace(n.X)
+			e.modified = true
 		}
 	case *ast.FieldList:
 		e.condenseFieldList(n)
@@ -67,16 +94,31 @@ func (e *condenser) applyPost(c *astutil.Cursor) bool { //nolint:cyclop,funlen,g
 		trim(e, n.Lbrace, n.Rbrace, n.List)
 	case *ast.CaseClause:
 		trimTop(e, n.Colon, n.End(), n.Body)
+		e.condenseCaseHeader(n.Case, n.Colon, n.List, &ast.CaseClause{Case: n.Case, List: n.List, Colon: n.Colon})
 	case *ast.CommClause:
 		trimTop(e, n.Colon, n.End(), n.Body)
+		e.condenseCaseHeader(n.Case, n.Colon, commOperands(n.Comm), &ast.CommClause{Case: n.Case, Comm: n.Comm, Colon: n.Colon})
 	case *ast.UnaryExpr:
 		if inner, ok := n.X.(*ast.CompositeLit); ok && n.Op == token.AND {
 			expected, ok := e.litElementType(n).(*ast.StarExpr)
 			if ok && equalExpr(inner.Type, expected.X) {
 				inner.Type = nil
 				c.Replace(inner)
+				e.modified = true
 			}
 		}
+	case *ast.StructType:
+		// The printer only keeps single-field struct types on one line, and
+		// named struct types are left expanded for readability.
+		if _, named := e.parent(1).(*ast.TypeSpec); !named && len(n.Fields.List) == 1 && !e.isSingleLine(n) {
+			e.condenseStructType(n)
+		}
+	case *ast.InterfaceType:
+		if feature, ok := e.constraintFeature(); ok && isTypeSet(n) && !e.isSingleLine(n) {
+			e.condenseUncommented(n, feature)
+		}
+	case *ast.FuncLit:
+		e.condenseFuncLit(n)
 	case *ast.CompositeLit:
 		e.condenseCompositeLit(n)
 	case *ast.CallExpr:
@@ -85,23 +127,36 @@ func (e *condenser) applyPost(c *astutil.Cursor) bool { //nolint:cyclop,funlen,g
 		// Each precedence-chain collapses atomically from its top; tighter
 		// sub-expressions (e.g. `b*c` in `a + b*c + d`) are their own chain.
 		if p, ok := e.parent(1).(*ast.BinaryExpr); !ok || n.Op.Precedence() > p.Op.Precedence() {
-			if !e.isSingleLine(n) && !e.hasComments(n) {
-				e.condenseNode(n)
+			if !e.isSingleLine(n) {
+				e.condenseUncommented(n, Expressions)
 			}
 		}
 	case *ast.SelectorExpr:
-		if !e.isSingleLine(n) && e.isSingleLine(n.X) && !e.hasComments(n) {
-			e.condenseNode(n)
+		if !e.isSingleLine(n) && e.isSingleLine(n.X) {
+			e.condenseUncommented(n, Expressions)
+		}
+	case *ast.TypeAssertExpr:
+		// Type is nil in type switch guards, i.e. `x.(type)`.
+		if n.Type != nil && !e.isSingleLine(n) && e.isSingleLine(n.X) && e.isSingleLine(n.Type) {
+			e.condenseUncommented(n, Expressions)
+		}
+	case *ast.IndexExpr:
+		if !e.isSingleLine(n) && e.isSingleLine(n.X) && e.isSingleLine(n.Index) {
+			e.condenseUncommented(n, Expressions)
 		}
 	case *ast.IndexListExpr:
-		if !e.isSingleLine(n) && e.isSingleLine(n.X) && !e.hasComments(n) &&
+		if !e.isSingleLine(n) && e.isSingleLine(n.X) &&
 			!slices.ContainsFunc(n.Indices, func(idx ast.Expr) bool { return !e.isSingleLine(idx) }) {
-			e.condenseNode(n)
+			e.condenseUncommented(n, Expressions)
 		}
 	case *ast.SliceExpr:
-		simplifySliceExpr(n)
+		if simplifySliceExpr(n) {
+			e.modified = true
+		}
 	case *ast.RangeStmt:
-		simplifyRangeStmt(n)
+		if simplifyRangeStmt(n) {
+			e.modified = true
+		}
 	case *ast.AssignStmt:
 		if !e.hasCommentsInRange(n.TokPos, n.Rhs[0].Pos()) {
 			e.removeLines(e.line(n.TokPos), e.line(n.Rhs[0].Pos()))
@@ -124,15 +179,20 @@ func (e *condenser) applyPost(c *astutil.Cursor) bool { //nolint:cyclop,funlen,g
 }
 
 // simplifyGenDecl simplifies grouped declarations. It trims blank lines in
-// multi-spec or commented groups, removes parens from single-spec groups, and
+// multi-spec, commented or preserved iota groups, removes parens from
+// single-spec groups (keeping a trailing comment on the spec line), and
 // reports whether the declaration is empty and should be deleted.
 func (e *condenser) simplifyGenDecl(decl *ast.GenDecl) bool {
 	switch {
 	case !decl.Lparen.IsValid():
-	case len(decl.Specs) > 1, e.hasComments(decl):
+	case hasCgoPreamble(decl):
+		// The preamble is C source that cgo reads from the doc comment of
+		// import "C", so the group is left exactly as written.
+	case len(decl.Specs) > 1, !e.canUnwrap(decl):
 		trim(e, decl.Lparen, decl.Rparen, decl.Specs)
 	case decl.Specs != nil:
 		start, end := e.line(decl.Lparen), e.line(decl.Rparen)
+		e.record(declFeature(decl), decl)
 		decl.Lparen, decl.Rparen = token.NoPos, token.NoPos
 		e.removeLines(e.line(decl.Specs[0].End()), end)
 		e.removeLines(start, e.line(decl.Specs[0].Pos()))
@@ -143,6 +203,97 @@ func (e *condenser) simplifyGenDecl(decl *ast.GenDecl) bool {
 	return false
 }
 
+// canUnwrap reports whether the parens of a group with at most one spec can
+// be removed, recording why not.
+func (e *condenser) canUnwrap(decl *ast.GenDecl) bool {
+	var reason Reason
+	switch {
+	case !e.inRanges(decl) || e.excluded() || slices.ContainsFunc(decl.Specs, e.declares):
+		return false
+	case e.changeLimitReached():
+		reason = ChangeLimit
+	case !e.enabled(declFeature(decl)):
+		reason = Disabled
+	case e.hasComments(decl) && (e.strict || !e.hasOnlyTrailingComment(decl)):
+		reason = HasComments
+	case len(decl.Specs) == 1 && !e.savesEnough(e.line(decl.Rparen)-e.line(decl.Lparen)):
+		reason = TooFewLinesSaved
+	case e.preserveIota && decl.Tok == token.CONST && usesIota(decl):
+		reason = PreservedIota
+	case len(decl.Specs) == 1 && !e.acceptsUnwrapped(decl):
+		reason = Rejected
+	default:
+		return true
+	}
+	e.skip(declFeature(decl), decl, reason)
+	return false
+}
+
+// declFeature returns the feature governing a declaration group.
+func declFeature(decl *ast.GenDecl) Feature {
+	if decl.Tok == token.IMPORT {
+		return Imports
+	}
+	return Declarations
+}
+
+// acceptsUnwrapped reports whether the Accept callback allows removing the
+// parens of the single-spec group decl.
+func (e *condenser) acceptsUnwrapped(decl *ast.GenDecl) bool {
+	if e.accept == nil {
+		return true
+	}
+	lparen, rparen := decl.Lparen, decl.Rparen
+	decl.Lparen, decl.Rparen = token.NoPos, token.NoPos
+	defer func() { decl.Lparen, decl.Rparen = lparen, rparen }()
+	return e.accept(declFeature(decl), decl, string(e.render(declFeature(decl), decl)))
+}
+
+// hasOnlyTrailingComment reports whether every comment in a single-spec group
+// trails the spec on its last line, so the group can be unwrapped without
+// moving any comment.
+func (e *condenser) hasOnlyTrailingComment(decl *ast.GenDecl) bool {
+	if len(decl.Specs) != 1 {
+		return false
+	}
+	spec := decl.Specs[0]
+	line := e.line(spec.End())
+	comments := e.file.Comments
+	i := sort.Search(len(comments), func(i int) bool { return comments[i].End() >= decl.Lparen })
+	for ; i < len(comments) && comments[i].Pos() <= decl.Rparen; i++ {
+		if comments[i].Pos() < spec.End() || e.line(comments[i].End()) != line {
+			return false
+		}
+	}
+	return true
+}
+
+// hasCgoPreamble reports whether decl imports "C" with a preceding comment,
+// which cgo treats as the preamble.
+func hasCgoPreamble(decl *ast.GenDecl) bool {
+	if decl.Tok != token.IMPORT {
+		return false
+	}
+	for _, spec := range decl.Specs {
+		if spec := spec.(*ast.ImportSpec); spec.Path.Value == `"C"` && (spec.Doc != nil || decl.Doc != nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// usesIota reports whether any value in the declaration references iota.
+func usesIota(decl *ast.GenDecl) bool {
+	found := false
+	ast.Inspect(decl, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == "iota" {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
 // canRemoveParens reports whether the parentheses can be safely removed.
 // Binary/unary parens are only stripped in unambiguous single-value contexts.
 // Parens around channel/func types, pointer derefs before postfix operators,
@@ -221,6 +372,20 @@ func hasExposedCompositeLit(expr ast.Expr) bool {
 	}
 }
 
+// fieldListOwner returns the node a field list is rendered with to check its
+// length. For function declarations this is the signature without the body,
+// so the name and receiver are counted but the lines of the body are not.
+func (e *condenser) fieldListOwner() ast.Node {
+	decl, ok := e.parent(1).(*ast.FuncDecl)
+	if _, isType := e.parent(1).(*ast.FuncType); isType {
+		decl, ok = e.parent(2).(*ast.FuncDecl)
+	}
+	if !ok {
+		return e.parent(1)
+	}
+	return &ast.FuncDecl{Recv: decl.Recv, Name: decl.Name, Type: decl.Type}
+}
+
 // condenseFieldList trims blank lines in a field list and, for type params
 // and function params/results/receivers, attempts to collapse it onto a
 // single line, merging adjacent fields with the same type.
@@ -238,18 +403,26 @@ func (e *condenser) condenseFieldList(list *ast.FieldList) {
 		return
 	}
 
-	if e.hasComments(list) {
-		return
-	}
-
+	feature := e.fieldListFeature(list)
 	startLine, endLine := e.line(list.Pos()), e.line(list.End())
-	if startLine == endLine {
-		mergeFields(list)
+	switch {
+	case e.hasComments(list):
+		if startLine != endLine {
+			e.skip(feature, list, HasComments)
+		}
+		return
+	case startLine == endLine:
+		if mergeFields(list) {
+			e.modified = true
+		}
+		return
+	case !e.eligible(feature, list, endLine-startLine):
 		return
 	}
 
 	for _, field := range list.List {
-		if !e.isSingleLine(field.Type) {
+		if !e.isSingleLine(field.Type) || !e.printsSingleLine(field.Type) {
+			e.skip(feature, list, MultiLineElement)
 			return
 		}
 	}
@@ -266,18 +439,70 @@ func (e *condenser) condenseFieldList(list *ast.FieldList) {
 	mergeFields(list)
 
 	// format.Node can't render a standalone FieldList, so verify against
-	// the parent node which IS renderable.
-	if !e.canCondense(e.parent(1)) {
+	// the node containing it, which IS renderable.
+	if reason := e.verify(feature, list, e.canCondense(e.fieldListOwner())); reason != Condensed {
 		e.restoreLines(startLine, startLine, savedLines)
 		list.List = savedFields
 		for i, f := range savedFields {
 			f.Names = savedNames[i]
 		}
+		e.skip(feature, list, reason)
+		return
+	}
+
+	e.record(feature, list)
+}
+
+// fieldListFeature returns the feature governing the field list being visited.
+func (e *condenser) fieldListFeature(list *ast.FieldList) Feature {
+	switch p := e.parent(1).(type) {
+	case *ast.TypeSpec:
+		return Types
+	case *ast.FuncType:
+		switch {
+		case isFuncLit(e.parent(2)):
+			return Literals
+		case list == p.TypeParams:
+			return TypeParams
+		case list == p.Results:
+			return Results
+		}
 	}
+	return Params
 }
 
-// mergeFields merges adjacent fields with the same type (e.g. `a T, b T` → `a, b T`).
-func mergeFields(list *ast.FieldList) {
+// constraintFeature returns the feature governing the interface being visited
+// if it is the constraint of a type parameter.
+func (e *condenser) constraintFeature() (Feature, bool) {
+	list, ok := e.parent(2).(*ast.FieldList)
+	if !ok {
+		return 0, false
+	}
+	switch p := e.parent(3).(type) {
+	case *ast.TypeSpec:
+		return Types, list == p.TypeParams
+	case *ast.FuncType:
+		return TypeParams, list == p.TypeParams
+	}
+	return 0, false
+}
+
+// isTypeSet reports whether iface consists of a single embedded type element,
+// e.g. `interface{ ~int | ~float64 }`.
+func isTypeSet(iface *ast.InterfaceType) bool {
+	return len(iface.Methods.List) == 1 && len(iface.Methods.List[0].Names) == 0
+}
+
+// isFuncLit reports whether node is a function literal.
+func isFuncLit(node ast.Node) bool {
+	_, ok := node.(*ast.FuncLit)
+	return ok
+}
+
+// mergeFields merges adjacent fields with the same type (e.g. `a T, b T` → `a, b T`)
+// and reports whether any were merged.
+func mergeFields(list *ast.FieldList) bool {
+	n := len(list.List)
 	for i := len(list.List) - 1; i > 0; i-- {
 		a, b := list.List[i-1], list.List[i]
 		if len(a.Names) > 0 && len(b.Names) > 0 && equalExpr(a.Type, b.Type) {
@@ -285,43 +510,213 @@ func mergeFields(list *ast.FieldList) {
 			list.List = slices.Delete(list.List, i, i+1)
 		}
 	}
+	return len(list.List) != n
 }
 
 // condenseCompositeLit elides redundant element types, trims blank lines, and
 // attempts to collapse multi-line composite literals onto a single line.
 // Literals with multi-line types are not collapsed. Key-value literals
 // (structs/maps) are only condensed when the first element shares a line with
-// the opening brace.
+// the opening brace. Index-keyed array and slice literals are treated as lists.
 func (e *condenser) condenseCompositeLit(lit *ast.CompositeLit) {
 	if lit.Type != nil {
 		if expected := e.litElementType(lit); equalExpr(expected, lit.Type) {
 			lit.Type = nil
+			e.modified = true
 		}
 	}
 
 	trim(e, lit.Lbrace, lit.Rbrace, lit.Elts)
-	if len(lit.Elts) == 0 || e.isSingleLine(lit) || e.hasComments(lit) {
+	if len(lit.Elts) == 0 || e.isSingleLine(lit) {
+		return
+	}
+
+	feature := e.litFeature(lit)
+	_, keyed := lit.Elts[0].(*ast.KeyValueExpr)
+	keyed = keyed && feature != Slices && feature != Arrays
+
+	switch {
+	case e.hasComments(lit):
+		e.skip(feature, lit, HasComments)
+	case keyed && e.line(lit.Lbrace) != e.line(lit.Elts[0].Pos()):
+		// Skip key-value literals whose first element is not on the same line as the opening brace.
+		e.skip(feature, lit, KeyNotOnBraceLine)
+	case !keyed && e.tooManyItems(len(lit.Elts)):
+		e.skip(feature, lit, TooManyItems)
+	case !e.isSingleLine(lit.Type) || slices.ContainsFunc(lit.Elts, func(elt ast.Expr) bool { return !e.isSingleLine(elt) }):
+		// All children are already condensed, so any multi-line type or element stays that way.
+		e.skip(feature, lit, MultiLineElement)
+	case e.tooDeep(lit.Elts):
+		e.skip(feature, lit, TooDeep)
+	default:
+		e.condenseNode(lit, feature)
+	}
+}
+
+// condenseFuncLit collapses the body of a function literal holding a single
+// statement onto the line of its signature, e.g. comparators and short
+// callbacks. The literal is left untouched if the printer would not keep the
+// body on one line.
+func (e *condenser) condenseFuncLit(lit *ast.FuncLit) {
+	body := lit.Body
+	if len(body.List) != 1 || e.isSingleLine(body) || !e.isSingleLine(lit.Type) {
 		return
 	}
 
-	// Skip key-value literals whose first element is not on the same line as the opening brace.
-	if _, kv := lit.Elts[0].(*ast.KeyValueExpr); kv && e.line(lit.Lbrace) != e.line(lit.Elts[0].Pos()) {
+	from, to := e.line(body.Lbrace), e.line(body.Rbrace)
+	switch {
+	case !e.eligible(Literals, lit, to-from):
+		return
+	case e.hasComments(body), e.strict && e.hasComments(lit):
+		e.skip(Literals, lit, HasComments)
+		return
+	case !e.isSingleLine(body.List[0]):
+		e.skip(Literals, lit, MultiLineElement)
 		return
 	}
 
-	// Skip composite literals where the type spans multiple lines.
-	if !e.isSingleLine(lit.Type) {
+	saved := e.saveLines(from, to)
+	e.removeLines(from, to)
+
+	if reason := e.verify(Literals, lit, e.printsSingleLine(lit) && e.canCondense(lit)); reason != Condensed {
+		e.restoreLines(from, from, saved)
+		e.skip(Literals, lit, reason)
 		return
 	}
 
-	// All children already condensed. Check they're all single-line.
-	for _, elt := range lit.Elts {
-		if !e.isSingleLine(elt) {
-			return
-		}
+	e.record(Literals, lit)
+}
+
+// condenseStructType collapses an inline struct type with a single field onto
+// one line, e.g. `struct{ A, B int }`.
+func (e *condenser) condenseStructType(st *ast.StructType) {
+	from, to := e.line(st.Pos()), e.line(st.End())
+	switch {
+	case !e.eligible(Structs, st, to-from):
+		return
+	case e.hasComments(st):
+		e.skip(Structs, st, HasComments)
+		return
+	case !e.isSingleLine(st.Fields.List[0]):
+		e.skip(Structs, st, MultiLineElement)
+		return
+	}
+
+	saved := e.saveLines(from, to)
+	e.removeLines(from, to)
+
+	if reason := e.verify(Structs, st, e.printsSingleLine(st) && e.canCondense(st)); reason != Condensed {
+		e.restoreLines(from, from, saved)
+		e.skip(Structs, st, reason)
+		return
+	}
+
+	e.record(Structs, st)
+}
+
+// condenseInvokedArgs condenses the arguments of a call whose function spans
+// multiple lines, such as an immediately invoked function literal, onto the
+// line the function ends on, e.g. `}(a, b)`.
+func (e *condenser) condenseInvokedArgs(call *ast.CallExpr, always bool) {
+	from, to := e.line(call.Lparen), e.line(call.Rparen)
+	switch {
+	case from == to, slices.ContainsFunc(call.Args, func(arg ast.Expr) bool { return !e.isSingleLine(arg) }):
+		e.skip(Calls, call, MultiLineElement)
+		return
+	case e.hasCommentsInRange(call.Lparen, call.Rparen):
+		e.skip(Calls, call, HasComments)
+		return
+	case e.tooDeep(call.Args) && !always:
+		e.skip(Calls, call, TooDeep)
+		return
+	case !e.eligible(Calls, call, to-from):
+		return
+	}
+
+	saved := e.saveLines(from, to)
+	e.removeLines(from, to)
+
+	if reason := e.verify(Calls, call, e.canCondense(call)); reason != Condensed {
+		e.restoreLines(from, from, saved)
+		e.skip(Calls, call, reason)
+		return
+	}
+
+	e.record(Calls, call)
+}
+
+// condenseCaseHeader collapses the header of a case or comm clause, from the
+// case keyword to the colon, onto a single line. Operands must already be
+// single-line. The header is the clause without its body, so that only the
+// header is measured against MaxLen.
+func (e *condenser) condenseCaseHeader(start, colon token.Pos, operands []ast.Expr, header ast.Stmt) {
+	from, to := e.line(start), e.line(colon)
+	switch {
+	case from == to, !e.eligible(Switches, header, to-from):
+		return
+	case e.hasCommentsInRange(start, colon):
+		e.skip(Switches, header, HasComments)
+		return
+	case slices.ContainsFunc(operands, func(x ast.Expr) bool { return !e.isSingleLine(x) }):
+		e.skip(Switches, header, MultiLineElement)
+		return
+	}
+
+	saved := e.saveLines(from, to)
+	e.removeLines(from, to)
+
+	if reason := e.verify(Switches, header, e.canCondense(header)); reason != Condensed {
+		e.restoreLines(from, from, saved)
+		e.skip(Switches, header, reason)
+		return
 	}
 
-	e.condenseNode(lit)
+	e.record(Switches, header)
+}
+
+// commOperands returns the channels and values of a select case's send or
+// receive statement.
+func commOperands(comm ast.Stmt) []ast.Expr {
+	switch s := comm.(type) {
+	case *ast.SendStmt:
+		return []ast.Expr{s.Chan, s.Value}
+	case *ast.ExprStmt:
+		return []ast.Expr{unwrapRecv(s.X)}
+	case *ast.AssignStmt:
+		return append(slices.Clone(s.Lhs), unwrapRecv(s.Rhs[0]))
+	}
+	return nil // default clause
+}
+
+// unwrapRecv returns the channel of a receive expression, or x itself.
+func unwrapRecv(x ast.Expr) ast.Expr {
+	if u, ok := x.(*ast.UnaryExpr); ok && u.Op == token.ARROW {
+		return u.X
+	}
+	return x
+}
+
+// litFeature returns the feature governing a composite literal, based on its
+// explicit type or the type elided from it.
+func (e *condenser) litFeature(lit *ast.CompositeLit) Feature {
+	typ := lit.Type
+	if typ == nil {
+		typ = e.litElementType(lit)
+		if star, ok := typ.(*ast.StarExpr); ok {
+			typ = star.X
+		}
+	}
+	switch typ := typ.(type) {
+	case *ast.ArrayType:
+		if typ.Len != nil {
+			return Arrays
+		}
+		return Slices
+	case *ast.MapType:
+		return Maps
+	default:
+		return Structs
+	}
 }
 
 // litElementType returns the type that a parent array, slice, or map composite
@@ -354,7 +749,20 @@ func (e *condenser) litElementType(node ast.Node) ast.Expr {
 // If only the last arg is multiline, condenses leading args onto the first line
 // and pulls the closing paren up after the trailing arg.
 func (e *condenser) condenseCallExpr(call *ast.CallExpr) {
-	if e.isSingleLine(call) || !e.isSingleLine(call.Fun) {
+	if e.isSingleLine(call) {
+		return
+	}
+
+	always := e.alwaysCondensed(call)
+	switch {
+	case !e.enabled(Calls) && !always:
+		e.skip(Calls, call, Disabled)
+		return
+	case e.tooManyItems(len(call.Args)) && !always:
+		e.skip(Calls, call, TooManyItems)
+		return
+	case !e.isSingleLine(call.Fun):
+		e.condenseInvokedArgs(call, always)
 		return
 	}
 
@@ -362,26 +770,43 @@ func (e *condenser) condenseCallExpr(call *ast.CallExpr) {
 	// len-1 means only the last is multiline, anything else we leave alone.
 	i := slices.IndexFunc(call.Args, func(arg ast.Expr) bool { return !e.isSingleLine(arg) })
 	if i == -1 {
-		if !e.hasComments(call) {
-			e.condenseNode(call)
+		if e.tooDeep(call.Args) && !always {
+			e.skip(Calls, call, TooDeep)
+			return
 		}
+		e.condenseUncommented(call, Calls)
 		return
 	}
 	if i != len(call.Args)-1 {
+		e.skip(Calls, call, MultiLineElement)
 		return
 	}
 
 	// Trailing multiline argument: last arg is multiline, all others are single-line.
 	lastArg := call.Args[i]
+	startLine, endLine := e.line(call.Lparen), e.line(call.Rparen)
+	argStartLine, argEndLine := e.line(lastArg.Pos()), e.line(lastArg.End())
+	if argStartLine == startLine && argEndLine == endLine {
+		return // Already hugs the parens.
+	}
 
 	// Only check for comments in the leading args and surrounding parens,
 	// not the last arg which stays multiline.
-	if e.hasCommentsInRange(call.Lparen, lastArg.Pos()-1) || e.hasCommentsInRange(lastArg.End(), call.Rparen) {
+	if e.strict && e.hasComments(call) ||
+		e.hasCommentsInRange(call.Lparen, lastArg.Pos()-1) || e.hasCommentsInRange(lastArg.End(), call.Rparen) {
+		e.skip(Calls, call, HasComments)
 		return
 	}
 
-	startLine, endLine := e.line(call.Lparen), e.line(call.Rparen)
-	argStartLine, argEndLine := e.line(lastArg.Pos()), e.line(lastArg.End())
+	// Only the leading args share the line; the last arg keeps its own lines.
+	if e.tooDeep(call.Args[:i]) && !always {
+		e.skip(Calls, call, TooDeep)
+		return
+	}
+
+	if !e.eligible(Calls, call, argStartLine-startLine+endLine-argEndLine) {
+		return
+	}
 
 	saved := e.saveLines(startLine, endLine)
 
@@ -389,9 +814,13 @@ func (e *condenser) condenseCallExpr(call *ast.CallExpr) {
 	e.removeLines(argEndLine, endLine)
 	e.removeLines(startLine, argStartLine)
 
-	if !e.canCondense(call) {
+	if reason := e.verify(Calls, call, e.canCondense(call)); reason != Condensed {
 		e.restoreLines(startLine, startLine+(argEndLine-argStartLine), saved)
+		e.skip(Calls, call, reason)
+		return
 	}
+
+	e.record(Calls, call)
 }
 
 // trim removes blank lines between the delimiters and their nearest children,
@@ -445,11 +874,13 @@ func trimTop[T ast.Node](e *condenser, start, end token.Pos, children []T) {
 }
 
 // simplifySliceExpr removes redundant len calls from 2-index slice upper
-// bounds and strips zero low bounds when the high bound is omitted.
-func simplifySliceExpr(expr *ast.SliceExpr) {
+// bounds and strips zero low bounds when the high bound is omitted. It reports
+// whether the expression was changed.
+func simplifySliceExpr(expr *ast.SliceExpr) bool {
 	if expr.Max != nil {
-		return
+		return false
 	}
+	high, low := expr.High, expr.Low
 	// Remove redundant len() upper bound, skipping expressions with side
 	// effects as the simplification reduces evaluation from twice to once.
 	if call, ok := expr.High.(*ast.CallExpr); ok && len(call.Args) == 1 {
@@ -475,16 +906,20 @@ func simplifySliceExpr(expr *ast.SliceExpr) {
 			expr.Low = nil
 		}
 	}
+	return expr.High != high || expr.Low != low
 }
 
-// simplifyRangeStmt removes blank identifiers from range statement variables.
-func simplifyRangeStmt(stmt *ast.RangeStmt) {
+// simplifyRangeStmt removes blank identifiers from range statement variables
+// and reports whether any were removed.
+func simplifyRangeStmt(stmt *ast.RangeStmt) bool {
+	key, value := stmt.Key, stmt.Value
 	if isBlankIdent(stmt.Value) {
 		stmt.Value = nil
 	}
 	if stmt.Value == nil && isBlankIdent(stmt.Key) {
 		stmt.Key = nil
 	}
+	return stmt.Key != key || stmt.Value != value
 }
 
 // isBlankIdent reports whether expr is the blank identifier _.
@@ -512,6 +947,17 @@ func (e *condenser) isSingleLine(node ast.Node) bool {
 	return node == nil || e.line(node.Pos()) == e.line(node.End())
 }
 
+// printsSingleLine reports whether node is rendered on a single line. Unlike
+// [condenser.isSingleLine], this catches nodes such as struct types with tags,
+// which the printer always expands even when they are on one line in the source.
+func (e *condenser) printsSingleLine(node ast.Node) bool {
+	e.buf.Reset()
+	if err := format.Node(e.buf, e.fset, node); err != nil {
+		panic("gocondense: format.Node failed: " + err.Error())
+	}
+	return !bytes.Contains(e.buf.Bytes(), []byte{'\n'})
+}
+
 // parent returns the nth ancestor from the parent stack (0 = self, 1 = parent, 2 = grandparent).
 func (e *condenser) parent(n int) ast.Node {
 	if i := len(e.parents) - 1 - n; i >= 0 {
@@ -520,6 +966,49 @@ func (e *condenser) parent(n int) ast.Node {
 	return nil
 }
 
+// enabled reports whether the feature is enabled.
+func (e *condenser) enabled(feature Feature) bool {
+	return e.features&feature != 0
+}
+
+// savesEnough reports whether removing n lines satisfies MinLinesSaved.
+func (e *condenser) savesEnough(n int) bool {
+	return n >= e.minLinesSaved
+}
+
+// tooManyItems reports whether a list of n items exceeds MaxCondenseItems.
+func (e *condenser) tooManyItems(n int) bool {
+	return e.maxItems > 0 && n > e.maxItems
+}
+
+// tooDeep reports whether a call or composite literal condensed onto a line
+// with the given elements would nest deeper than MaxNestDepth.
+func (e *condenser) tooDeep(elts []ast.Expr) bool {
+	return e.maxNestDepth > 0 && 1+maxDepth(elts) > e.maxNestDepth
+}
+
+// maxDepth returns the deepest nesting of calls and composite literals among
+// exprs. Function literal bodies are not counted as they span their own lines.
+func maxDepth(exprs []ast.Expr) int {
+	var depth int
+	for _, x := range exprs {
+		ast.Inspect(x, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.FuncLit:
+				return false
+			case *ast.CallExpr:
+				depth = max(depth, 1+maxDepth(n.Args))
+				return false
+			case *ast.CompositeLit:
+				depth = max(depth, 1+maxDepth(n.Elts))
+				return false
+			}
+			return true
+		})
+	}
+	return depth
+}
+
 // line returns the line number for a position.
 func (e *condenser) line(pos token.Pos) int {
 	return e.tokenFile.Line(pos)
@@ -545,26 +1034,50 @@ func (e *condenser) removeLines(fromLine, toLine int) {
 	e.tokenFile.SetLines(append(lines[:fromLine], lines[toLine:]...))
 }
 
+// verify reports why node, laid out on fewer lines, must be restored, or
+// [Condensed] if it can be kept. fits reports whether it fits within MaxLen.
+func (e *condenser) verify(feature Feature, node ast.Node, fits bool) Reason {
+	switch {
+	case !fits:
+		return TooLong
+	case e.accept != nil && !e.accept(feature, node, string(e.render(feature, node))):
+		return Rejected
+	}
+	return Condensed
+}
+
 // canCondense checks whether the rendered node fits within MaxLen.
 // It formats the node via format.Node and checks every output line against
-// the limit, accounting for indentation and tab width.
+// the limit, accounting for indentation, tab width, and trailing comments.
 func (e *condenser) canCondense(node ast.Node) bool {
+	if e.maxLen < 0 {
+		return true // No line length limit.
+	}
+
 	e.buf.Reset()
 	if err := format.Node(e.buf, e.fset, node); err != nil {
 		panic("gocondense: format.Node failed: " + err.Error())
 	}
 
 	startCol := e.startColumn(node.Pos())
+	if e.excludeIndent {
+		startCol -= e.indentLevel * e.tabWidth
+	}
+	trailing := e.trailingWidth(node.End())
 
-	first := true
-	lines := bytes.SplitSeq(e.buf.Bytes(), []byte{'\n'})
-	for line := range lines {
+	lines := bytes.Split(e.buf.Bytes(), []byte{'\n'})
+	for i, line := range lines {
+		if e.excludeIndent {
+			line = bytes.TrimLeft(line, "\t")
+		}
 		// Each tab is already counted as 1 byte by len(line), so we add (tabWidth-1)
 		// per tab to get the correct visual width without double-counting.
 		length := len(line) + bytes.Count(line, []byte{'\t'})*(e.tabWidth-1)
-		if first {
+		if i == 0 {
 			length += startCol
-			first = false
+		}
+		if i == len(lines)-1 {
+			length += trailing // Whatever follows the node ends up on the last line.
 		}
 		if length > e.maxLen {
 			return false // If any line exceeds MaxLen, we cannot condense.
@@ -574,6 +1087,17 @@ func (e *condenser) canCondense(node ast.Node) bool {
 	return true
 }
 
+// trailingWidth returns the distance from pos to the end of its line, which
+// covers both code and comments that follow pos, such as a sibling that was
+// already condensed onto the same line.
+func (e *condenser) trailingWidth(pos token.Pos) int {
+	end := e.tokenFile.Size()
+	if line := e.line(pos); line < e.tokenFile.LineCount() {
+		end = e.tokenFile.Offset(e.tokenFile.LineStart(line+1)) - 1 // Exclude the newline.
+	}
+	return end - e.tokenFile.Offset(pos)
+}
+
 // startColumn returns the visual column where pos begins on its line.
 // It walks up the parent stack to find the topmost ancestor on the same line,
 // then computes: indentLevel * tabWidth + byte distance from ancestor to pos.
@@ -597,21 +1121,187 @@ func (e *condenser) startColumn(pos token.Pos) int {
 
 // condenseNode attempts to condense a node by removing lines between its positions.
 // If the condensed result would exceed MaxLen, the line table is restored.
-func (e *condenser) condenseNode(node ast.Node) {
+func (e *condenser) condenseNode(node ast.Node, feature Feature) {
 	from := e.line(node.Pos())
 	to := e.line(node.End())
-	if from >= to {
+	if from >= to || !e.eligible(feature, node, to-from) {
 		return
 	}
 
 	saved := e.saveLines(from, to)
 	e.removeLines(from, to)
 
-	if !e.canCondense(node) {
+	if reason := e.verify(feature, node, e.canCondense(node)); reason != Condensed {
 		e.restoreLines(from, from, saved)
+		e.skip(feature, node, reason)
+		return
+	}
+
+	e.record(feature, node)
+}
+
+// condenseUncommented condenses node unless it contains comments.
+func (e *condenser) condenseUncommented(node ast.Node, feature Feature) {
+	if e.hasComments(node) {
+		e.skip(feature, node, HasComments)
+		return
+	}
+	e.condenseNode(node, feature)
+}
+
+// eligible reports whether a construct that would save the given number of
+// lines may be condensed as part of feature, recording why not.
+func (e *condenser) eligible(feature Feature, node ast.Node, lines int) bool {
+	switch {
+	case !e.inRanges(node) || e.excluded():
+		return false
+	case e.changeLimitReached():
+		e.skip(feature, node, ChangeLimit)
+	case e.alwaysCondensed(node):
+		return true
+	case !e.enabled(feature):
+		e.skip(feature, node, Disabled)
+	case !e.savesEnough(lines):
+		e.skip(feature, node, TooFewLinesSaved)
+	default:
+		return true
+	}
+	return false
+}
+
+// changeLimitReached reports whether MaxChangesPerFile constructs have been
+// condensed.
+func (e *condenser) changeLimitReached() bool {
+	return e.maxChanges > 0 && e.applied >= e.maxChanges
+}
+
+// alwaysCondensed reports whether node is a call to a function in
+// AlwaysCondenseCalls.
+func (e *condenser) alwaysCondensed(node ast.Node) bool {
+	call, ok := node.(*ast.CallExpr)
+	if !ok || len(e.alwaysCalls) == 0 {
+		return false
+	}
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		return slices.Contains(e.alwaysCalls, fun.Name)
+	case *ast.SelectorExpr:
+		x, ok := fun.X.(*ast.Ident)
+		return ok && slices.Contains(e.alwaysCalls, x.Name+"."+fun.Sel.Name)
+	}
+	return false
+}
+
+// inRanges reports whether node overlaps the line ranges to condense.
+func (e *condenser) inRanges(node ast.Node) bool {
+	if e.ranges == nil {
+		return true
+	}
+	start, end := e.origLine(node.Pos()), e.origLine(node.End())
+	return slices.ContainsFunc(e.ranges, func(r LineRange) bool { return r.Start <= end && start <= r.End })
+}
+
+// excluded reports whether the current node is part of the declaration of a
+// name in Exclude.
+func (e *condenser) excluded() bool {
+	if len(e.exclude) == 0 {
+		return false
+	}
+	return slices.ContainsFunc(e.parents, func(node ast.Node) bool {
+		switch n := node.(type) {
+		case *ast.FuncDecl:
+			return slices.Contains(e.exclude, n.Name.Name)
+		case ast.Spec:
+			return e.declares(n)
+		}
+		return false
+	})
+}
+
+// declares reports whether spec declares a name in Exclude.
+func (e *condenser) declares(spec ast.Spec) bool {
+	switch s := spec.(type) {
+	case *ast.TypeSpec:
+		return slices.Contains(e.exclude, s.Name.Name)
+	case *ast.ValueSpec:
+		return slices.ContainsFunc(s.Names, func(name *ast.Ident) bool { return slices.Contains(e.exclude, name.Name) })
+	}
+	return false
+}
+
+// changed reports whether the file was changed. Lines removed while trying to
+// condense a construct are restored if it is left as is, so the line table
+// only differs in length once lines have been removed for good.
+func (e *condenser) changed() bool {
+	return e.modified || e.tokenFile.LineCount() != len(e.lines)
+}
+
+// record notes that node was condensed as part of feature. Its condensed
+// size is measured by [condenser.measure] once the whole file is processed.
+func (e *condenser) record(feature Feature, node ast.Node) {
+	e.changes = append(e.changes, Change{
+		Feature: feature,
+		Line:    e.origLine(node.Pos()),
+		EndLine: e.origLine(node.End()),
+		Before:  e.tokenFile.Offset(node.End()) - e.tokenFile.Offset(node.Pos()),
+	})
+	e.condensed = append(e.condensed, node)
+	e.modified = true
+	e.applied++
+}
+
+// measure sets the After size of each condensed change.
+func (e *condenser) measure() {
+	for i, node := range e.condensed {
+		if node == nil {
+			continue
+		}
+		e.changes[i].After = len(e.render(e.changes[i].Feature, node))
 	}
 }
 
+// render formats node, condensed as part of feature, into e.buf and returns
+// the output.
+func (e *condenser) render(feature Feature, node ast.Node) []byte {
+	var prefix, suffix int
+	if list, ok := node.(*ast.FieldList); ok {
+		// format.Node can't render a standalone FieldList, so render it as
+		// part of a func type and cut out its brackets. The only field lists
+		// condensed as types are type parameters, as struct fields aren't.
+		if feature == TypeParams || feature == Types {
+			node, prefix, suffix = &ast.FuncType{TypeParams: list, Params: &ast.FieldList{}}, len("func"), len("()")
+		} else {
+			node, prefix = &ast.FuncType{Params: list}, len("func")
+		}
+	}
+	e.buf.Reset()
+	if err := format.Node(e.buf, e.fset, node); err != nil {
+		panic("gocondense: format.Node failed: " + err.Error())
+	}
+	return e.buf.Bytes()[prefix : e.buf.Len()-suffix]
+}
+
+// skip notes that node was left as is for the given reason, if diagnosing.
+func (e *condenser) skip(feature Feature, node ast.Node, reason Reason) {
+	if !e.diagnose {
+		return
+	}
+	e.changes = append(e.changes, Change{
+		Feature: feature,
+		Line:    e.origLine(node.Pos()),
+		EndLine: e.origLine(node.End()),
+		Reason:  reason,
+	})
+	e.condensed = append(e.condensed, nil)
+}
+
+// origLine returns the line number of pos in the original source, before any
+// lines were removed.
+func (e *condenser) origLine(pos token.Pos) int {
+	offset := e.tokenFile.Offset(pos)
+	return sort.Search(len(e.lines), func(i int) bool { return e.lines[i] > offset })
+}
+
 // equalExpr reports whether two AST type expressions are structurally equal.
 func equalExpr(a, b ast.Expr) bool { //nolint:cyclop,gocognit
 	if a == nil || b == nil {
@@ -0,0 +1,118 @@
+package gocondense
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Feature identifies a category of constructs the formatter condenses.
+// Features can be combined with bitwise OR.
+type Feature uint
+
+// Features that can be condensed.
+//
+// Arrays and Imports were split out of Slices and Declarations, which no
+// longer cover array literals and import groups. A set of features naming
+// Slices or Declarations explicitly must add Arrays or Imports to keep
+// condensing them.
+const (
+	Declarations Feature = 1 << iota // single-spec var, const, and type declaration groups
+	Types                            // type parameter lists of type declarations
+	TypeParams                       // type parameter lists of functions
+	Params                           // parameter lists and receivers of functions
+	Results                          // result lists of functions
+	Literals                         // function literal signatures and bodies
+	Calls                            // call argument lists
+	Structs                          // struct literals and inline struct types
+	Slices                           // slice literals
+	Maps                             // map literals
+	Expressions                      // binary, selector, and index expressions
+	Switches                         // case clause lists and select comm clauses
+	Arrays                           // fixed-size array literals
+	Imports                          // single-spec import declaration groups
+)
+
+// Groups of features.
+const (
+	// Funcs condenses function and method signatures.
+	Funcs = TypeParams | Params | Results
+
+	// Signatures condenses declarations and signatures, leaving the calls
+	// and composite literals in function bodies untouched.
+	Signatures = Declarations | Imports | Types | Funcs | Literals
+
+	// BodyLiterals condenses calls and composite literals.
+	BodyLiterals = Calls | Structs | Slices | Arrays | Maps
+
+	// All condenses every supported construct.
+	All = Declarations | Types | Funcs | Literals | Calls | Structs | Slices | Maps | Expressions | Switches | Arrays | Imports
+)
+
+var featureNames = []string{
+	"declarations",
+	"types",
+	"type-params",
+	"params",
+	"results",
+	"literals",
+	"calls",
+	"structs",
+	"slices",
+	"maps",
+	"expressions",
+	"switches",
+	"arrays",
+	"imports",
+}
+
+// featureGroups maps the names of feature groups to their features.
+var featureGroups = map[string]Feature{
+	"all":           All,
+	"funcs":         Funcs,
+	"signatures":    Signatures,
+	"body-literals": BodyLiterals,
+}
+
+// String returns the comma-separated names of the features in f, or "all" if
+// every feature is set.
+func (f Feature) String() string {
+	if f == All {
+		return "all"
+	}
+	var names []string
+	for i, name := range featureNames {
+		if f&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// MarshalText implements [encoding.TextMarshaler].
+func (f Feature) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler]. It accepts a
+// comma-separated list of feature and group names, e.g. "funcs,calls".
+func (f *Feature) UnmarshalText(text []byte) error {
+	var features Feature
+	for name := range strings.SplitSeq(string(text), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if group, ok := featureGroups[name]; ok {
+			features |= group
+			continue
+		}
+		i := slices.Index(featureNames, name)
+		if i < 0 {
+			return fmt.Errorf("unknown feature %q", name)
+		}
+		features |= 1 << i
+	}
+	*f = features
+	return nil
+}
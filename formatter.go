@@ -2,12 +2,18 @@ package gocondense
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"go/ast"
 	"go/format"
 	"go/parser"
 	"go/token"
+	"reflect"
+	"runtime"
+	"slices"
+	"sync"
 
+	"golang.org/x/sync/semaphore"
 	"golang.org/x/tools/go/ast/astutil"
 )
 
@@ -15,17 +21,100 @@ import (
 type Config struct {
 	// MaxLen is the maximum line length before keeping constructs multi-line.
 	// Lines exceeding this limit will not be condensed.
-	// If 0, defaults to 80 characters.
+	// If 0, defaults to 80 characters. If negative, line length is not limited
+	// and every eligible construct is condensed.
 	MaxLen int
 
+	// MaxLenExcludesIndent measures lines against MaxLen without their
+	// leading indentation, making MaxLen a limit on content width rather
+	// than on the column a line ends at.
+	MaxLenExcludesIndent bool
+
 	// TabWidth is the number of spaces that represent a tab character
-	// when calculating line lengths.
+	// when calculating line lengths, including the indentation of the line.
+	// Set to 1 to count each tab as a single column.
 	// If 0, defaults to 4 spaces.
 	TabWidth int
+
+	// Features selects which constructs are condensed. Simplifications such
+	// as trimming blank lines and removing parentheses are always applied.
+	// If 0, defaults to [All]. Array literals and import groups are governed
+	// by [Arrays] and [Imports], not [Slices] and [Declarations].
+	Features Feature
+
+	// MinLinesSaved is the minimum number of lines a construct must shrink by
+	// to be condensed. Constructs that would save fewer lines are left as is.
+	// If 0, every construct that fits within MaxLen is condensed.
+	MinLinesSaved int
+
+	// MaxCondenseItems is the maximum number of elements an unkeyed composite
+	// literal or argument list may have to be condensed. Longer lists are kept
+	// expanded even if they fit within MaxLen.
+	// If 0, there is no limit.
+	MaxCondenseItems int
+
+	// MaxNestDepth is the maximum number of calls and composite literals that
+	// may be nested within each other on a condensed line, e.g. 2 allows
+	// `f(T{1})` but keeps `f(T{g(1)})` expanded. Inner constructs are still
+	// condensed if they are shallow enough.
+	// If 0, there is no limit.
+	MaxNestDepth int
+
+	// MaxChangesPerFile is the maximum number of constructs condensed in a
+	// file, so that legacy code can be condensed gradually. Once reached,
+	// remaining constructs are left as is; simplifications such as trimming
+	// blank lines are still applied.
+	// If 0, there is no limit.
+	MaxChangesPerFile int
+
+	// Exclude lists the names of functions, methods, types, variables, and
+	// constants whose declarations are left as is, e.g. a hand-tuned table.
+	// Blank lines and parentheses within them are still simplified.
+	Exclude []string
+
+	// AlwaysCondenseCalls lists functions, such as "fmt.Errorf" or "errors.New",
+	// whose calls are condensed even if [Calls] is not enabled and regardless
+	// of MinLinesSaved, MaxCondenseItems and MaxNestDepth. They must still fit
+	// within MaxLen. Names are matched against the called identifier or
+	// package-qualified selector.
+	AlwaysCondenseCalls []string
+
+	// Accept is called with each construct about to be condensed, along with
+	// the construct's condensed source, and can veto it by returning false,
+	// e.g. to enforce project-specific rules. It can only veto: constructs
+	// left as is because of MaxLen or another limit are never passed to it.
+	// While it runs, the file's line table is being edited, so positions of
+	// the node resolve to correct offsets through the FileSet, but their line
+	// and column numbers reflect the partly condensed file.
+	// If nil, every eligible construct is condensed.
+	Accept func(feature Feature, node ast.Node, condensed string) bool `json:"-"`
+
+	// PreserveIotaBlocks keeps const groups that use iota as groups, even when
+	// they contain a single spec, so further constants can be added later.
+	PreserveIotaBlocks bool
+
+	// StrictComments prevents condensing any construct that contains a
+	// comment anywhere within it. By default, comments that would keep their
+	// line, such as those inside the trailing argument of a call or trailing a
+	// single-spec declaration group, don't prevent condensing.
+	StrictComments bool
+
+	// Normalize formats the source with gofmt before condensing, so that
+	// line lengths are measured from canonical indentation and spacing
+	// rather than however the input happens to be laid out. Callers parsing
+	// source for [Formatter.File] themselves can apply it with
+	// [Formatter.Normalize].
+	Normalize bool
+
+	// FinalNewline controls whether the output of [Formatter.Source] ends
+	// with a newline. Callers rendering the result of [Formatter.File]
+	// themselves can apply it with [Formatter.FinalNewline].
+	// If 0, defaults to [NewlineAlways].
+	FinalNewline Newline
 }
 
 var (
-	defaultConfig    = Config{MaxLen: 80, TabWidth: 4}
+	defaultConfig    = Config{MaxLen: 80, TabWidth: 4, Features: All}
 	defaultFormatter = New(defaultConfig)
 )
 
@@ -35,16 +124,30 @@ func Source(src []byte) ([]byte, error) {
 	return defaultFormatter.Source(src)
 }
 
+// SourceWithConfig formats Go source code using the given configuration.
+// It is shorthand for New(config).Source(src).
+func SourceWithConfig(src []byte, config Config) ([]byte, error) {
+	return New(config).Source(src)
+}
+
+// SourceString is like [Source] but takes and returns a string.
+func SourceString(src string) (string, error) {
+	return defaultFormatter.SourceString(src)
+}
+
 // Formatter condenses Go code according to the specified configuration.
+// A Formatter is immutable once created and safe for concurrent use by
+// multiple goroutines.
 type Formatter struct {
 	config Config
 }
 
 // New creates a new formatter with the given configuration.
-// Zero fields are replaced with their [Config] defaults.
+// Zero fields are replaced with their [Config] defaults. The configuration is
+// copied, so later changes to config do not affect the formatter.
 func New(config Config) *Formatter {
-	if config.MaxLen < 0 || config.TabWidth < 0 {
-		panic("gocondense: MaxLen and TabWidth must not be negative")
+	if config.TabWidth < 0 {
+		panic("gocondense: TabWidth must not be negative")
 	}
 	if config.MaxLen == 0 {
 		config.MaxLen = defaultConfig.MaxLen
@@ -52,43 +155,270 @@ func New(config Config) *Formatter {
 	if config.TabWidth == 0 {
 		config.TabWidth = defaultConfig.TabWidth
 	}
+	if config.Features == 0 {
+		config.Features = defaultConfig.Features
+	}
+	config.Exclude = slices.Clone(config.Exclude)
+	config.AlwaysCondenseCalls = slices.Clone(config.AlwaysCondenseCalls)
 	return &Formatter{config: config}
 }
 
+// Config returns the configuration of the formatter, with zero fields
+// replaced by their defaults.
+func (f *Formatter) Config() Config {
+	config := f.config
+	config.Exclude = slices.Clone(config.Exclude)
+	config.AlwaysCondenseCalls = slices.Clone(config.AlwaysCondenseCalls)
+	return config
+}
+
 // Source processes Go source code and returns a condensed version.
 // The formatter respects the configured limits, only condensing constructs
 // that fit within the specified constraints.
 // Returns the formatted source code or an error if parsing or formatting fails.
 func (f *Formatter) Source(src []byte) ([]byte, error) {
+	input, err := f.Normalize(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse source: %w", err)
+	}
+
 	fset := token.NewFileSet()
 
-	file, err := parser.ParseFile(fset, "", src, parser.ParseComments|parser.SkipObjectResolution)
+	file, err := parser.ParseFile(fset, "", input, parser.ParseComments|parser.SkipObjectResolution)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse source: %w", err)
 	}
 
-	f.File(fset, file)
+	if c := f.condense(fset, file, false, nil); !c.changed() && f.config.Normalize {
+		// The input is already gofmt output, which is what printing the
+		// untouched file would produce, so skip printing it again.
+		return f.FinalNewline(src, input), nil
+	}
 
 	buf := bytes.NewBuffer(make([]byte, 0, len(src)))
 	if err := format.Node(buf, fset, file); err != nil {
 		return nil, fmt.Errorf("failed to format AST: %w", err)
 	}
 
-	return buf.Bytes(), nil
+	return f.FinalNewline(src, buf.Bytes()), nil
+}
+
+// Normalize formats src with gofmt if [Config.Normalize] is set, and returns it
+// unchanged otherwise. Callers parsing source for [Formatter.File] themselves
+// can apply it before parsing.
+func (f *Formatter) Normalize(src []byte) ([]byte, error) {
+	if !f.config.Normalize {
+		return src, nil
+	}
+	return format.Source(src)
+}
+
+// FinalNewline adjusts the trailing newline of out, formatted from src,
+// according to the configured [Config.FinalNewline] mode.
+func (f *Formatter) FinalNewline(src, out []byte) []byte {
+	return f.config.FinalNewline.apply(src, out)
+}
+
+// SourceString is like [Formatter.Source] but takes and returns a string.
+func (f *Formatter) SourceString(src string) (string, error) {
+	out, err := f.Source([]byte(src))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// SourceAll formats each of the given files concurrently and returns the
+// results and errors keyed by filename. A failure in one file does not affect
+// the others; files that fail to format only appear in the error map.
+func (f *Formatter) SourceAll(files map[string][]byte) (map[string][]byte, map[string]error) {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		sem  = semaphore.NewWeighted(int64(runtime.NumCPU()))
+		out  = make(map[string][]byte, len(files))
+		errs = make(map[string]error)
+	)
+
+	for name, src := range files {
+		_ = sem.Acquire(context.Background(), 1)
+		wg.Add(1)
+		go func() {
+			defer sem.Release(1)
+			defer wg.Done()
+			res, err := f.Source(src)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[name] = err
+			} else {
+				out[name] = res
+			}
+		}()
+	}
+	wg.Wait()
+
+	return out, errs
+}
+
+// Change describes a construct condensed by [Formatter.File], or left as is
+// and reported by [Formatter.Diagnose].
+type Change struct {
+	Feature Feature // category of the construct
+	Line    int     // first line of the construct in the original source
+	EndLine int     // last line of the construct in the original source
+	Reason  Reason  // why the construct was left as is, or Condensed
+	Before  int     // length of the construct in the original source, if condensed
+	After   int     // length of the construct once condensed, if condensed
+}
+
+// LineRange is an inclusive range of 1-based line numbers.
+type LineRange struct {
+	Start int
+	End   int
 }
 
 // File condenses the given AST file in-place. The caller is responsible for
 // parsing and for rendering the result (e.g. via format.Node).
 func (f *Formatter) File(fset *token.FileSet, file *ast.File) {
+	f.file(fset, file, false, nil)
+}
+
+// FileChanges is like [Formatter.File] but also returns the constructs it
+// condensed, in the order they were processed.
+func (f *Formatter) FileChanges(fset *token.FileSet, file *ast.File) []Change {
+	return f.file(fset, file, false, nil)
+}
+
+// FileLines is like [Formatter.FileChanges] but only condenses constructs that
+// overlap one of the given line ranges of the original source. Simplifications
+// such as trimming blank lines are still applied to the whole file.
+func (f *Formatter) FileLines(fset *token.FileSet, file *ast.File, lines []LineRange) []Change {
+	if lines == nil {
+		lines = []LineRange{}
+	}
+	return f.file(fset, file, false, lines)
+}
+
+// Diagnose is like [Formatter.FileChanges] but also reports the multi-line constructs
+// that were left as is, with the [Reason] each one wasn't condensed.
+func (f *Formatter) Diagnose(fset *token.FileSet, file *ast.File) []Change {
+	return f.file(fset, file, true, nil)
+}
+
+// file condenses file. If ranges is non-nil, only constructs overlapping one
+// of them are condensed.
+func (f *Formatter) file(fset *token.FileSet, file *ast.File, diagnose bool, ranges []LineRange) []Change {
+	return f.condense(fset, file, diagnose, ranges).changes
+}
+
+// ShouldCondense reports whether the formatter would condense node, a
+// multi-line construct within file, e.g. a call, composite literal, field list
+// or declaration group. The answer is advisory: it is computed on a copy of
+// file as laid out by the printer, in the context of the whole file, so it may
+// differ from formatting the original source. The file is not modified.
+//
+// Each call prints, parses and condenses the whole file. To query many nodes
+// of the same file, use [Formatter.CondensedNodes] instead.
+func (f *Formatter) ShouldCondense(fset *token.FileSet, file *ast.File, node ast.Node) bool {
+	return node != nil && slices.Contains(f.CondensedNodes(fset, file), node)
+}
+
+// CondensedNodes returns the nodes of file the formatter would condense, in
+// the order they would be condensed. Like [Formatter.ShouldCondense] the
+// answer is advisory and the file is not modified, but the file is only
+// printed, parsed and condensed once.
+func (f *Formatter) CondensedNodes(fset *token.FileSet, file *ast.File) []ast.Node {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		return nil
+	}
+	copyFset := token.NewFileSet()
+	copyFile, err := parser.ParseFile(copyFset, "", buf.Bytes(), parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil
+	}
+
+	// Map the copy back to file by depth-first position. Case clause headers
+	// are recorded as a clone of the clause, so also index by position and
+	// type. Both are captured before condensing edits the copy.
+	type key struct {
+		pos token.Pos
+		typ reflect.Type
+	}
+	orig := inspectOrder(file)
+	byNode := make(map[ast.Node]int)
+	byKey := make(map[key]int)
+	for i, n := range inspectOrder(copyFile) {
+		byNode[n] = i
+		if _, ok := byKey[key{n.Pos(), reflect.TypeOf(n)}]; !ok {
+			byKey[key{n.Pos(), reflect.TypeOf(n)}] = i
+		}
+	}
+
+	var nodes []ast.Node
+	seen := make(map[ast.Node]bool)
+	c := f.condense(copyFset, copyFile, false, nil)
+	for _, n := range c.condensed {
+		if n == nil {
+			continue
+		}
+		i, ok := byNode[n]
+		if !ok {
+			i, ok = byKey[key{n.Pos(), reflect.TypeOf(n)}]
+		}
+		// The printed copy may not mirror file exactly, e.g. if file was built
+		// by hand, so skip nodes that don't line up.
+		if !ok || i >= len(orig) || reflect.TypeOf(orig[i]) != reflect.TypeOf(n) || seen[orig[i]] {
+			continue
+		}
+		seen[orig[i]] = true
+		nodes = append(nodes, orig[i])
+	}
+	return nodes
+}
+
+// inspectOrder returns the nodes of root in depth-first order.
+func inspectOrder(root ast.Node) []ast.Node {
+	var nodes []ast.Node
+	ast.Inspect(root, func(n ast.Node) bool {
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+		return true
+	})
+	return nodes
+}
+
+// condense runs a condenser over file and returns it.
+func (f *Formatter) condense(fset *token.FileSet, file *ast.File, diagnose bool, ranges []LineRange) *condenser {
+	tokenFile := fset.File(file.Pos())
 	c := &condenser{
-		maxLen:    f.config.MaxLen,
-		tabWidth:  f.config.TabWidth,
-		fset:      fset,
-		file:      file,
-		tokenFile: fset.File(file.Pos()),
-		buf:       bytes.NewBuffer(make([]byte, 0, 4096)),
-		parents:   make([]ast.Node, 0, 32),
+		maxLen:        f.config.MaxLen,
+		tabWidth:      f.config.TabWidth,
+		excludeIndent: f.config.MaxLenExcludesIndent,
+		features:      f.config.Features,
+		minLinesSaved: f.config.MinLinesSaved,
+		maxItems:      f.config.MaxCondenseItems,
+		maxNestDepth:  f.config.MaxNestDepth,
+		maxChanges:    f.config.MaxChangesPerFile,
+		exclude:       f.config.Exclude,
+		alwaysCalls:   f.config.AlwaysCondenseCalls,
+		accept:        f.config.Accept,
+		preserveIota:  f.config.PreserveIotaBlocks,
+		strict:        f.config.StrictComments,
+		diagnose:      diagnose,
+		ranges:        ranges,
+		fset:          fset,
+		file:          file,
+		tokenFile:     tokenFile,
+		buf:           bytes.NewBuffer(make([]byte, 0, 4096)),
+		parents:       make([]ast.Node, 0, 32),
+		lines:         slices.Clone(tokenFile.Lines()),
 	}
 
 	astutil.Apply(file, c.applyPre, c.applyPost)
+	c.measure()
+
+	return c
 }
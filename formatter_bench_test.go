@@ -2,9 +2,12 @@ package gocondense_test
 
 import (
 	"cmp"
+	"embed"
 	"fmt"
 	"go/parser"
 	"go/token"
+	"io/fs"
+	"path"
 	"slices"
 	"strings"
 	"testing"
@@ -12,6 +15,84 @@ import (
 	"github.com/abemedia/gocondense"
 )
 
+//go:embed testdata/bench/*.input
+var benchFS embed.FS
+
+// BenchmarkSource formats the fixtures in testdata/bench: small and medium
+// files, a pathologically nested literal, and a large real-world file.
+func BenchmarkSource(b *testing.B) {
+	matches, err := fs.Glob(benchFS, "testdata/bench/*.input")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, name := range matches {
+		src, err := benchFS.ReadFile(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(strings.TrimSuffix(path.Base(name), ".input"), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(src)))
+			for b.Loop() {
+				if _, err := gocondense.Source(src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSourceUnchanged formats the fixtures in testdata/bench once they
+// are already condensed, with Normalize set, which skips printing them again.
+func BenchmarkSourceUnchanged(b *testing.B) {
+	formatter := gocondense.New(gocondense.Config{Normalize: true})
+	matches, err := fs.Glob(benchFS, "testdata/bench/*.input")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, name := range matches {
+		src, err := benchFS.ReadFile(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if src, err = formatter.Source(src); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(strings.TrimSuffix(path.Base(name), ".input"), func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(src)))
+			for b.Loop() {
+				if _, err := formatter.Source(src); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSourceDefault compares the package-level Source, which reuses a
+// shared formatter, with creating a formatter for every call.
+func BenchmarkSourceDefault(b *testing.B) {
+	src := []byte("package main\n\nvar _ = []int{\n\t1,\n\t2,\n}\n")
+	config := gocondense.Config{MaxLen: 80, TabWidth: 4, Features: gocondense.All}
+	b.Run("Source", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := gocondense.Source(src); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("SourceWithConfig", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := gocondense.SourceWithConfig(src, config); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
 func BenchmarkFile(b *testing.B) {
 	formatter := gocondense.New(gocondense.Config{})
 	for _, n := range []int{100, 1000} {
@@ -1,10 +1,22 @@
 package gocondense_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -55,6 +67,97 @@ func TestFormat(t *testing.T) {
 	}
 }
 
+// TestNegative checks that constructs which must stay multi-line are left as
+// gofmt would format them.
+func TestNegative(t *testing.T) {
+	matches, err := filepath.Glob("testdata/negative/*.input")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, inputFile := range matches {
+		t.Run(strings.TrimSuffix(filepath.Base(inputFile), ".input"), func(t *testing.T) {
+			input, err := os.ReadFile(inputFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := format.Source(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := gocondense.Source(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(want), string(got)); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// TestIdempotent checks that formatting the output of every fixture, including
+// the benchmark corpus, leaves it unchanged.
+func TestIdempotent(t *testing.T) {
+	matches, err := filepath.Glob("testdata/*.input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bench, err := filepath.Glob("testdata/bench/*.input")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, inputFile := range append(matches, bench...) {
+		t.Run(strings.TrimSuffix(inputFile, ".input"), func(t *testing.T) {
+			input, err := os.ReadFile(inputFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			once, err := gocondense.Source(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			twice, err := gocondense.Source(once)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(once), string(twice)); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+// TestGofmtStable checks that the output of every fixture is left unchanged by
+// gofmt, so declarations aligned with a condensed neighbour are realigned.
+func TestGofmtStable(t *testing.T) {
+	matches, err := filepath.Glob("testdata/*.input")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, inputFile := range matches {
+		t.Run(strings.TrimSuffix(filepath.Base(inputFile), ".input"), func(t *testing.T) {
+			input, err := os.ReadFile(inputFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := gocondense.Source(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := format.Source(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(string(want), string(got)); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
 func TestFormatter(t *testing.T) {
 	uncondensed := `package main
 
@@ -75,6 +178,31 @@ import "fmt"
 func greet(first, last string) string {
 	return fmt.Sprintf("Hello, %s %s!", first, last)
 }
+`
+
+	// The condensed call is 27 columns wide, nested 3 levels deep.
+	nested := `package main
+
+func f() {
+	if true {
+		if true {
+			fmt.Println(
+				"hello",
+				world,
+			)
+		}
+	}
+}
+`
+	nestedCondensed := `package main
+
+func f() {
+	if true {
+		if true {
+			fmt.Println("hello", world)
+		}
+	}
+}
 `
 
 	tests := []struct {
@@ -117,18 +245,421 @@ func greet(first, last string) string {
 			want:  condensed,
 		},
 		{
-			name: "negative_max_len",
+			name:   "indent_depth_allows_condensing",
+			config: gocondense.Config{MaxLen: 39}, // 3*4+27
+			input:  nested,
+			want:   nestedCondensed,
+		},
+		{
+			name:   "indent_depth_prevents_condensing",
+			config: gocondense.Config{MaxLen: 38},
+			input:  nested,
+			want:   nested,
+		},
+		{
+			name:   "tab_width_one_allows_condensing",
+			config: gocondense.Config{MaxLen: 30, TabWidth: 1}, // 3*1+27
+			input:  nested,
+			want:   nestedCondensed,
+		},
+		{
+			name:   "tab_width_one_prevents_condensing",
+			config: gocondense.Config{MaxLen: 29, TabWidth: 1},
+			input:  nested,
+			want:   nested,
+		},
+		{
+			name:   "features_disable_calls",
+			config: gocondense.Config{Features: gocondense.All &^ gocondense.Calls},
+			input:  uncondensed,
+			want:   uncondensed,
+		},
+		{
+			name:   "features_condense_results_only",
+			config: gocondense.Config{Features: gocondense.Results},
+			input:  "package main\n\nfunc f(\n\ta int,\n\tb string,\n) (\n\tint,\n\terror,\n) {\n\treturn 0, nil\n}\n",
+			want:   "package main\n\nfunc f(\n\ta int,\n\tb string,\n) (int, error) {\n\treturn 0, nil\n}\n",
+		},
+		{
+			name:   "features_condense_params_only",
+			config: gocondense.Config{Features: gocondense.Params},
+			input:  "package main\n\nfunc f[\n\tT any,\n](\n\ta T,\n) (\n\tint,\n\terror,\n) {\n\treturn 0, nil\n}\n",
+			want:   "package main\n\nfunc f[\n\tT any,\n](a T) (\n\tint,\n\terror,\n) {\n\treturn 0, nil\n}\n",
+		},
+		{
+			name:   "features_disable_literals_keeps_func_lit_body",
+			config: gocondense.Config{Features: gocondense.All &^ gocondense.Literals},
+			input:  "package main\n\nvar f = func() int {\n\treturn 1\n}\n",
+			want:   "package main\n\nvar f = func() int {\n\treturn 1\n}\n",
+		},
+		{
+			// The printer only keeps function bodies of up to 100 columns on one line.
+			name:   "func_lit_body_exceeding_printer_limit",
+			config: gocondense.Config{MaxLen: 200},
+			input:  "package main\n\nvar _ = f(\n\ta,\n\tfunc() string {\n\t\treturn \"" + strings.Repeat("a", 100) + "\"\n\t},\n\tb,\n)\n",
+			want:   "package main\n\nvar _ = f(\n\ta,\n\tfunc() string {\n\t\treturn \"" + strings.Repeat("a", 100) + "\"\n\t},\n\tb,\n)\n",
+		},
+		{
+			name: "min_lines_saved_allows_condensing",
+			config: gocondense.Config{
+				MinLinesSaved: 4, // 5 lines condensed to 1
+			},
+			input: uncondensed,
+			want:  condensed,
+		},
+		{
+			name: "min_lines_saved_prevents_condensing",
+			config: gocondense.Config{
+				MinLinesSaved: 5,
+			},
+			input: uncondensed,
+			want:  uncondensed,
+		},
+		{
+			name: "min_lines_saved_keeps_declaration_group",
+			config: gocondense.Config{
+				MinLinesSaved: 4, // 4 lines unwrapped to 1
+			},
+			input: "package main\n\nvar (\n\n\tx = 1\n)\n",
+			want:  "package main\n\nvar (\n\tx = 1\n)\n",
+		},
+		{
+			name:   "max_condense_items_allows_condensing",
+			config: gocondense.Config{MaxCondenseItems: 3},
+			input:  "package main\n\nvar _ = []int{\n\t1,\n\t2,\n\t3,\n}\n",
+			want:   "package main\n\nvar _ = []int{1, 2, 3}\n",
+		},
+		{
+			name:   "max_condense_items_keeps_slice_expanded",
+			config: gocondense.Config{MaxCondenseItems: 3},
+			input:  "package main\n\nvar _ = []int{\n\t1,\n\t2,\n\t3,\n\t4,\n}\n",
+			want:   "package main\n\nvar _ = []int{\n\t1,\n\t2,\n\t3,\n\t4,\n}\n",
+		},
+		{
+			name:   "max_condense_items_keeps_call_expanded",
+			config: gocondense.Config{MaxCondenseItems: 2},
+			input:  uncondensed,
+			want:   uncondensed,
+		},
+		{
+			name:   "arrays_disabled_keeps_array_expanded",
+			config: gocondense.Config{Features: gocondense.All &^ gocondense.Arrays},
+			input:  "package main\n\nvar _ = [3]int{\n\t1,\n\t2,\n\t3,\n}\n\nvar _ = [...]string{\n\t0: \"a\",\n}\n",
+			want:   "package main\n\nvar _ = [3]int{\n\t1,\n\t2,\n\t3,\n}\n\nvar _ = [...]string{\n\t0: \"a\",\n}\n",
+		},
+		{
+			name:   "imports_disabled_keeps_import_group",
+			config: gocondense.Config{Features: gocondense.All &^ gocondense.Imports},
+			input:  "package main\n\nimport (\n\t\"fmt\"\n)\n\nvar (\n\tx = fmt.Sprint(1)\n)\n",
+			want:   "package main\n\nimport (\n\t\"fmt\"\n)\n\nvar x = fmt.Sprint(1)\n",
+		},
+		{
+			name:   "declarations_disabled_unwraps_import_group",
+			config: gocondense.Config{Features: gocondense.All &^ gocondense.Declarations},
+			input:  "package main\n\nimport (\n\t\"fmt\"\n)\n\nvar (\n\tx = fmt.Sprint(1)\n)\n",
+			want:   "package main\n\nimport \"fmt\"\n\nvar (\n\tx = fmt.Sprint(1)\n)\n",
+		},
+		{
+			name:   "arrays_disabled_condenses_slices",
+			config: gocondense.Config{Features: gocondense.All &^ gocondense.Arrays},
+			input:  "package main\n\nvar _ = []int{\n\t1,\n\t2,\n\t3,\n}\n",
+			want:   "package main\n\nvar _ = []int{1, 2, 3}\n",
+		},
+		{
+			name:   "slices_disabled_condenses_arrays",
+			config: gocondense.Config{Features: gocondense.All &^ gocondense.Slices},
+			input:  "package main\n\nvar _ = [3]int{\n\t1,\n\t2,\n\t3,\n}\n\nvar _ = []int{\n\t1,\n}\n",
+			want:   "package main\n\nvar _ = [3]int{1, 2, 3}\n\nvar _ = []int{\n\t1,\n}\n",
+		},
+		{
+			name:   "max_condense_items_keeps_index_keyed_array_expanded",
+			config: gocondense.Config{MaxCondenseItems: 1},
+			input:  "package main\n\nvar _ = [...]int{\n\t0: 1,\n\t5: 2,\n}\n",
+			want:   "package main\n\nvar _ = [...]int{\n\t0: 1,\n\t5: 2,\n}\n",
+		},
+		{
+			name:   "max_condense_items_ignores_keyed_literals",
+			config: gocondense.Config{MaxCondenseItems: 1},
+			input:  "package main\n\nvar _ = map[string]int{\"a\": 1,\n\t\"b\": 2,\n}\n",
+			want:   "package main\n\nvar _ = map[string]int{\"a\": 1, \"b\": 2}\n",
+		},
+		{
+			name:   "max_nest_depth_keeps_outer_literal_expanded",
+			config: gocondense.Config{MaxNestDepth: 2},
+			input:  "package main\n\nvar _ = []Outer{\n\t{Inner: []int{\n\t\t1,\n\t}},\n}\n",
+			want:   "package main\n\nvar _ = []Outer{\n\t{Inner: []int{1}},\n}\n",
+		},
+		{
+			name:   "max_nest_depth_allows_shallow_literal",
+			config: gocondense.Config{MaxNestDepth: 3},
+			input:  "package main\n\nvar _ = []Outer{\n\t{Inner: []int{\n\t\t1,\n\t}},\n}\n",
+			want:   "package main\n\nvar _ = []Outer{{Inner: []int{1}}}\n",
+		},
+		{
+			name:   "max_nest_depth_keeps_outer_call_expanded",
+			config: gocondense.Config{MaxNestDepth: 2},
+			input:  "package main\n\nvar _ = f(\n\tg(\n\t\th(1),\n\t),\n)\n",
+			want:   "package main\n\nvar _ = f(\n\tg(h(1)),\n)\n",
+		},
+		{
+			name:   "max_nest_depth_ignores_trailing_func_lit",
+			config: gocondense.Config{MaxNestDepth: 1},
+			input:  "package main\n\nvar _ = f(\n\ta,\n\tfunc() {\n\t\tg(h(1))\n\t\tg(h(2))\n\t},\n)\n",
+			want:   "package main\n\nvar _ = f(a, func() {\n\tg(h(1))\n\tg(h(2))\n})\n",
+		},
+		{
+			name:   "exclude_keeps_named_func",
+			config: gocondense.Config{Exclude: []string{"keep"}},
+			input:  "package main\n\nfunc keep(\n\ta int,\n) {\n\tf(\n\t\ta,\n\t)\n}\n\nfunc other(\n\ta int,\n) {\n\tf(\n\t\ta,\n\t)\n}\n",
+			want:   "package main\n\nfunc keep(\n\ta int,\n) {\n\tf(\n\t\ta,\n\t)\n}\n\nfunc other(a int) {\n\tf(a)\n}\n",
+		},
+		{
+			name:   "exclude_keeps_named_var",
+			config: gocondense.Config{Exclude: []string{"table"}},
+			input:  "package main\n\nvar (\n\ttable = []int{\n\t\t1,\n\t}\n)\n\nvar other = []int{\n\t1,\n}\n",
+			want:   "package main\n\nvar (\n\ttable = []int{\n\t\t1,\n\t}\n)\n\nvar other = []int{1}\n",
+		},
+		{
+			name:   "exclude_keeps_named_type",
+			config: gocondense.Config{Exclude: []string{"Pair"}},
+			input:  "package main\n\ntype Pair[\n\tK any,\n\tV any,\n] struct{}\n",
+			want:   "package main\n\ntype Pair[\n\tK any,\n\tV any,\n] struct{}\n",
+		},
+		{
+			name:   "always_condense_calls_ignores_max_nest_depth",
+			config: gocondense.Config{MaxNestDepth: 1, AlwaysCondenseCalls: []string{"fmt.Errorf"}},
+			input:  "package main\n\nvar _ = fmt.Errorf(\n\t\"%s: %w\",\n\tname(x),\n\terr,\n)\n\nvar _ = g(\n\th(x),\n)\n",
+			want:   "package main\n\nvar _ = fmt.Errorf(\"%s: %w\", name(x), err)\n\nvar _ = g(\n\th(x),\n)\n",
+		},
+		{
+			name:   "always_condense_calls_ignores_max_condense_items",
+			config: gocondense.Config{MaxCondenseItems: 1, AlwaysCondenseCalls: []string{"append"}},
+			input:  "package main\n\nvar _ = append(\n\ts,\n\t1,\n\t2,\n)\n\nvar _ = g(\n\ta,\n\tb,\n)\n",
+			want:   "package main\n\nvar _ = append(s, 1, 2)\n\nvar _ = g(\n\ta,\n\tb,\n)\n",
+		},
+		{
+			name:   "always_condense_calls_with_calls_disabled",
+			config: gocondense.Config{Features: gocondense.Declarations, AlwaysCondenseCalls: []string{"errors.New"}},
+			input:  "package main\n\nvar _ = errors.New(\n\t\"failed\",\n)\n\nvar _ = errors.Is(\n\terr,\n\ttarget,\n)\n",
+			want:   "package main\n\nvar _ = errors.New(\"failed\")\n\nvar _ = errors.Is(\n\terr,\n\ttarget,\n)\n",
+		},
+		{
+			name:   "always_condense_calls_respects_max_len",
+			config: gocondense.Config{MaxLen: 30, AlwaysCondenseCalls: []string{"fmt.Errorf"}},
+			input:  "package main\n\nvar _ = fmt.Errorf(\n\t\"failed: %w\",\n\terr,\n)\n",
+			want:   "package main\n\nvar _ = fmt.Errorf(\n\t\"failed: %w\",\n\terr,\n)\n",
+		},
+		{
+			name:   "max_len_counts_condensed_siblings_on_line",
+			config: gocondense.Config{MaxLen: 40},
+			input:  "package main\n\nvar _ = foo(\n\t\"aaaaaaaaaaaa\",\n) + bar(\n\t\"bbbbbbbbbbbb\",\n)\n",
+			want:   "package main\n\nvar _ = foo(\"aaaaaaaaaaaa\") + bar(\n\t\"bbbbbbbbbbbb\",\n)\n",
+		},
+		{
+			name:   "max_len_counts_code_after_construct",
+			config: gocondense.Config{MaxLen: 40},
+			input:  "package main\n\nvar _ = foo(\n\t\"aaaaaaaaaaaa\",\n) + barbarbarbarbar\n",
+			want:   "package main\n\nvar _ = foo(\n\t\"aaaaaaaaaaaa\",\n) + barbarbarbarbar\n",
+		},
+		{
+			name:   "max_len_includes_indent_by_default_depth_1",
+			config: gocondense.Config{MaxLen: 37},
+			input:  "package main\n\nfunc f() {\n\t_ = f(\n\t\t\"aaaaaaaaaa\",\n\t\t\"bbbbbbbbbb\",\n\t)\n}\n",
+			want:   "package main\n\nfunc f() {\n\t_ = f(\"aaaaaaaaaa\", \"bbbbbbbbbb\")\n}\n",
+		},
+		{
+			name:   "max_len_includes_indent_by_default_depth_2",
+			config: gocondense.Config{MaxLen: 37},
+			input:  "package main\n\nfunc f() {\n\tif x {\n\t\t_ = f(\n\t\t\t\"aaaaaaaaaa\",\n\t\t\t\"bbbbbbbbbb\",\n\t\t)\n\t}\n}\n",
+			want:   "package main\n\nfunc f() {\n\tif x {\n\t\t_ = f(\n\t\t\t\"aaaaaaaaaa\",\n\t\t\t\"bbbbbbbbbb\",\n\t\t)\n\t}\n}\n",
+		},
+		{
+			name:   "max_len_excludes_indent_depth_2",
+			config: gocondense.Config{MaxLen: 37, MaxLenExcludesIndent: true},
+			input:  "package main\n\nfunc f() {\n\tif x {\n\t\t_ = f(\n\t\t\t\"aaaaaaaaaa\",\n\t\t\t\"bbbbbbbbbb\",\n\t\t)\n\t}\n}\n",
+			want:   "package main\n\nfunc f() {\n\tif x {\n\t\t_ = f(\"aaaaaaaaaa\", \"bbbbbbbbbb\")\n\t}\n}\n",
+		},
+		{
+			name:   "max_len_excludes_indent_depth_3",
+			config: gocondense.Config{MaxLen: 37, MaxLenExcludesIndent: true},
+			input:  "package main\n\nfunc f() {\n\tif x {\n\t\tif x {\n\t\t\t_ = f(\n\t\t\t\t\"aaaaaaaaaa\",\n\t\t\t\t\"bbbbbbbbbb\",\n\t\t\t)\n\t\t}\n\t}\n}\n",
+			want:   "package main\n\nfunc f() {\n\tif x {\n\t\tif x {\n\t\t\t_ = f(\"aaaaaaaaaa\", \"bbbbbbbbbb\")\n\t\t}\n\t}\n}\n",
+		},
+		{
+			name:   "max_len_excludes_indent_content_too_long",
+			config: gocondense.Config{MaxLen: 32, MaxLenExcludesIndent: true},
+			input:  "package main\n\nfunc f() {\n\t_ = f(\n\t\t\"aaaaaaaaaa\",\n\t\t\"bbbbbbbbbb\",\n\t)\n}\n",
+			want:   "package main\n\nfunc f() {\n\t_ = f(\n\t\t\"aaaaaaaaaa\",\n\t\t\"bbbbbbbbbb\",\n\t)\n}\n",
+		},
+		{
+			name:   "max_changes_per_file",
+			config: gocondense.Config{MaxChangesPerFile: 2},
+			input:  "package main\n\nvar a = f(\n\t1,\n)\n\nvar b = f(\n\t2,\n)\n\nvar c = f(\n\t3,\n)\n",
+			want:   "package main\n\nvar a = f(1)\n\nvar b = f(2)\n\nvar c = f(\n\t3,\n)\n",
+		},
+		{
+			name:   "max_changes_per_file_still_simplifies",
+			config: gocondense.Config{MaxChangesPerFile: 1},
+			input:  "package main\n\nvar (\n\ta = f(\n\t\t1,\n\t)\n)\n\nvar b = (g(\n\t2,\n))\n",
+			want:   "package main\n\nvar (\n\ta = f(1)\n)\n\nvar b = g(\n\t2,\n)\n",
+		},
+		{
+			name:  "comment_in_trailing_arg_condensed_by_default",
+			input: "package main\n\nvar _ = f(\n\ta,\n\tfunc() {\n\t\tb() // comment\n\t\tc()\n\t},\n)\n",
+			want:  "package main\n\nvar _ = f(a, func() {\n\tb() // comment\n\tc()\n})\n",
+		},
+		{
+			name:   "strict_comments_keeps_trailing_arg_call",
+			config: gocondense.Config{StrictComments: true},
+			input:  "package main\n\nvar _ = f(\n\ta,\n\tfunc() {\n\t\tb() // comment\n\t\tc()\n\t},\n)\n",
+			want:   "package main\n\nvar _ = f(\n\ta,\n\tfunc() {\n\t\tb() // comment\n\t\tc()\n\t},\n)\n",
+		},
+		{
+			name:   "strict_comments_keeps_declaration_group",
+			config: gocondense.Config{StrictComments: true},
+			input:  "package main\n\nvar (\n\tx = 1 // comment\n)\n",
+			want:   "package main\n\nvar (\n\tx = 1 // comment\n)\n",
+		},
+		{
+			name:   "strict_comments_keeps_func_lit",
+			config: gocondense.Config{StrictComments: true},
+			input:  "package main\n\nvar f = func(a int /* a */) int {\n\treturn a\n}\n",
+			want:   "package main\n\nvar f = func(a int /* a */) int {\n\treturn a\n}\n",
+		},
+		{
+			name:   "strict_comments_condenses_uncommented",
+			config: gocondense.Config{StrictComments: true},
+			input:  uncondensed,
+			want:   condensed,
+		},
+		{
+			name:   "preserve_iota_blocks_keeps_iota_group",
+			config: gocondense.Config{PreserveIotaBlocks: true},
+			input:  "package main\n\nconst (\n\tA = iota\n)\n",
+			want:   "package main\n\nconst (\n\tA = iota\n)\n",
+		},
+		{
+			name:   "preserve_iota_blocks_unwraps_other_groups",
+			config: gocondense.Config{PreserveIotaBlocks: true},
+			input:  "package main\n\nconst (\n\tA = 1\n)\n",
+			want:   "package main\n\nconst A = 1\n",
+		},
+		{
+			name:  "iota_group_unwrapped_by_default",
+			input: "package main\n\nconst (\n\tA = iota\n)\n",
+			want:  "package main\n\nconst A = iota\n",
+		},
+		{
+			name:  "empty_group_in_function_deleted",
+			input: "package main\n\nfunc f() {\n\tx()\n\n\tvar ()\n\n\tconst (\n\t)\n\n\ty()\n}\n",
+			want:  "package main\n\nfunc f() {\n\tx()\n\n\ty()\n}\n",
+		},
+		{
+			name:  "single_spec_group_in_function_unwrapped",
+			input: "package main\n\nfunc f() {\n\tvar (\n\t\tx = 1\n\t)\n\t_ = x\n}\n",
+			want:  "package main\n\nfunc f() {\n\tvar x = 1\n\t_ = x\n}\n",
+		},
+		{
+			name:  "empty_group_under_label_kept",
+			input: "package main\n\nfunc f() {\nL:\n\tvar ()\n\tgoto L\n}\n",
+			want:  "package main\n\nfunc f() {\nL:\n\tvar ()\n\tgoto L\n}\n",
+		},
+		{
+			name: "negative_max_len_condenses_regardless_of_length",
 			config: gocondense.Config{
 				MaxLen: -1,
 			},
-			wantPanic: "gocondense: MaxLen and TabWidth must not be negative",
+			input: "package main\n\nvar _ = f(\n\t\"" + strings.Repeat("a", 150) + "\",\n\t\"" + strings.Repeat("b", 150) + "\",\n)\n",
+			want:  "package main\n\nvar _ = f(\"" + strings.Repeat("a", 150) + "\", \"" + strings.Repeat("b", 150) + "\")\n",
+		},
+		{
+			name:  "irregular_spacing_measured_as_is",
+			input: "package main\n\nfunc f() {\n\t_ =" + strings.Repeat(" ", 40) + "g(\n\t\t\"aaaaaaaaaaaaaaaa\",\n\t\t\"bbbbbbbbbbbbbbbb\",\n\t)\n}\n",
+			want:  "package main\n\nfunc f() {\n\t_ = g(\n\t\t\"aaaaaaaaaaaaaaaa\",\n\t\t\"bbbbbbbbbbbbbbbb\",\n\t)\n}\n",
+		},
+		{
+			name:   "normalize_irregular_spacing",
+			config: gocondense.Config{Normalize: true},
+			input:  "package main\n\nfunc f() {\n\t_ =" + strings.Repeat(" ", 40) + "g(\n\t\t\"aaaaaaaaaaaaaaaa\",\n\t\t\"bbbbbbbbbbbbbbbb\",\n\t)\n}\n",
+			want:   "package main\n\nfunc f() {\n\t_ = g(\"aaaaaaaaaaaaaaaa\", \"bbbbbbbbbbbbbbbb\")\n}\n",
+		},
+		{
+			name:   "normalize_unchanged",
+			config: gocondense.Config{Normalize: true},
+			input:  "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n",
+			want:   "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n",
+		},
+		{
+			name:   "normalize_removes_parens",
+			config: gocondense.Config{Normalize: true},
+			input:  "package main\n\nvar x = (1)\n",
+			want:   "package main\n\nvar x = 1\n",
+		},
+		{
+			name:   "normalize_simplifies_slice",
+			config: gocondense.Config{Normalize: true},
+			input:  "package main\n\nvar t = s[:len(s)]\n",
+			want:   "package main\n\nvar t = s[:]\n",
+		},
+		{
+			name:   "normalize_simplifies_range",
+			config: gocondense.Config{Normalize: true},
+			input:  "package main\n\nfunc f() {\n\tfor i, _ := range s {\n\t\t_ = i\n\t}\n}\n",
+			want:   "package main\n\nfunc f() {\n\tfor i := range s {\n\t\t_ = i\n\t}\n}\n",
+		},
+		{
+			name:   "normalize_elides_literal_type",
+			config: gocondense.Config{Normalize: true},
+			input:  "package main\n\nvar _ = []T{T{1}}\n",
+			want:   "package main\n\nvar _ = []T{{1}}\n",
+		},
+		{
+			name:   "normalize_elides_pointer_literal_type",
+			config: gocondense.Config{Normalize: true},
+			input:  "package main\n\nvar _ = []*T{&T{1}}\n",
+			want:   "package main\n\nvar _ = []*T{{1}}\n",
+		},
+		{
+			name:   "normalize_merges_params",
+			config: gocondense.Config{Normalize: true},
+			input:  "package main\n\nfunc f(a int, b int) {}\n",
+			want:   "package main\n\nfunc f(a, b int) {}\n",
+		},
+		{
+			name:   "normalize_deletes_empty_group",
+			config: gocondense.Config{Normalize: true},
+			input:  "package main\n\nvar ()\n",
+			want:   "package main\n",
+		},
+		{
+			name:  "final_newline_always_by_default",
+			input: "package main\n\nvar (\n\tx = 1\n)",
+			want:  "package main\n\nvar x = 1\n",
+		},
+		{
+			name:   "final_newline_keep_without_newline",
+			config: gocondense.Config{FinalNewline: gocondense.NewlineKeep},
+			input:  "package main\n\nvar (\n\tx = 1\n)",
+			want:   "package main\n\nvar x = 1",
+		},
+		{
+			name:   "final_newline_keep_with_newline",
+			config: gocondense.Config{FinalNewline: gocondense.NewlineKeep},
+			input:  "package main\n\nvar (\n\tx = 1\n)\n\n",
+			want:   "package main\n\nvar x = 1\n",
+		},
+		{
+			name:   "final_newline_never",
+			config: gocondense.Config{FinalNewline: gocondense.NewlineNever},
+			input:  "package main\n\nvar (\n\tx = 1\n)\n",
+			want:   "package main\n\nvar x = 1",
 		},
 		{
 			name: "negative_tab_width",
 			config: gocondense.Config{
 				TabWidth: -1,
 			},
-			wantPanic: "gocondense: MaxLen and TabWidth must not be negative",
+			wantPanic: "gocondense: TabWidth must not be negative",
 		},
 		{
 			name:    "invalid_syntax",
@@ -162,3 +693,649 @@ func greet(first, last string) string {
 		})
 	}
 }
+
+// TestElementOrder condenses randomly generated nested calls and literals and
+// checks that no element is reordered.
+func TestElementOrder(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	name := regexp.MustCompile(`x\d+`)
+
+	for i := range 500 {
+		var n int
+		src := "package p\n\nvar _ = " + randomExpr(r, 0, &n) + "\n"
+		config := gocondense.Config{MaxLen: []int{-1, 40, 80}[i%3]}
+
+		got, err := gocondense.New(config).Source([]byte(src))
+		if err != nil {
+			t.Fatalf("%v\n%s", err, src)
+		}
+
+		want := name.FindAllString(src, -1)
+		if diff := cmp.Diff(want, name.FindAllString(string(got), -1)); diff != "" {
+			t.Fatalf("element order changed (-want +got):\n%s\ninput:\n%s\noutput:\n%s", diff, src, got)
+		}
+	}
+}
+
+// randomExpr returns a multi-line call, slice, map, or struct literal with up
+// to three levels of nesting. Elements are named x0, x1, ... in source order.
+func randomExpr(r *rand.Rand, depth int, n *int) string {
+	next := func() string {
+		*n++
+		return "x" + strconv.Itoa(*n)
+	}
+
+	kind := r.IntN(5)
+	if depth == 3 {
+		kind = 0
+	}
+
+	var open, close string
+	keyed := false
+	switch kind {
+	case 0:
+		return next()
+	case 1:
+		open, close = next()+"(", ")"
+	case 2:
+		open, close = "[]any{", "}"
+	case 3:
+		open, close, keyed = "map[string]any{", "}", true
+	case 4:
+		open, close, keyed = "T{", "}", true
+	}
+
+	var b strings.Builder
+	b.WriteString(open)
+	for i := range 1 + r.IntN(4) {
+		if i > 0 || !keyed {
+			b.WriteString("\n") // Keyed literals only condense with the first element on the brace line.
+		}
+		if keyed {
+			if kind == 3 {
+				b.WriteString(strconv.Quote(next()) + ": ")
+			} else {
+				b.WriteString(next() + ": ")
+			}
+		}
+		b.WriteString(randomExpr(r, depth+1, n) + ",")
+	}
+	b.WriteString("\n" + close)
+	return b.String()
+}
+
+func TestSourceAll(t *testing.T) {
+	files := map[string][]byte{
+		"a.go":   []byte("package a\n\nvar (\n\tx = 1\n)\n"),
+		"b.go":   []byte("package b\n\nvar _ = []int{\n\t1,\n\t2,\n}\n"),
+		"bad.go": []byte("package bad\n\nfunc {"),
+		"nil.go": nil,
+	}
+
+	got, errs := gocondense.New(gocondense.Config{}).SourceAll(files)
+
+	want := map[string]string{
+		"a.go": "package a\n\nvar x = 1\n",
+		"b.go": "package b\n\nvar _ = []int{1, 2}\n",
+	}
+	if diff := cmp.Diff(want, toStrings(got)); diff != "" {
+		t.Error(diff)
+	}
+	for _, name := range []string{"bad.go", "nil.go"} {
+		if errs[name] == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	if len(errs) != 2 {
+		t.Errorf("errors = %v, want 2", errs)
+	}
+}
+
+func TestSourceWithConfig(t *testing.T) {
+	src := []byte("package main\n\nvar _ = []string{\n\t\"hello\",\n\t\"world\",\n}\n")
+
+	got, err := gocondense.SourceWithConfig(src, gocondense.Config{MaxLen: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(string(src), string(got)); diff != "" {
+		t.Error(diff)
+	}
+
+	got, err = gocondense.SourceWithConfig(src, gocondense.Config{MaxLen: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("package main\n\nvar _ = []string{\"hello\", \"world\"}\n", string(got)); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	exclude := []string{"table"}
+	formatter := gocondense.New(gocondense.Config{MaxLen: -1, Features: gocondense.Calls, Exclude: exclude})
+	exclude[0] = "changed"
+
+	want := gocondense.Config{MaxLen: -1, TabWidth: 4, Features: gocondense.Calls, Exclude: []string{"table"}}
+	got := formatter.Config()
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+	got.Exclude[0] = "changed"
+	if diff := cmp.Diff(want, formatter.Config()); diff != "" {
+		t.Error(diff)
+	}
+
+	b, err := json.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded gocondense.Config
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, decoded); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestSourceString(t *testing.T) {
+	got, err := gocondense.SourceString("package main\n\nvar (\n\tx = 1\n)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "package main\n\nvar x = 1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got, err = gocondense.New(gocondense.Config{MaxLen: 20}).SourceString("package main\n\nvar _ = []string{\n\t\"hello\",\n\t\"world\",\n}\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "package main\n\nvar _ = []string{\n\t\"hello\",\n\t\"world\",\n}\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if got, err := gocondense.SourceString("package main\n\nfunc {"); err == nil || got != "" {
+		t.Errorf("got %q, %v, want error", got, err)
+	}
+}
+
+func TestFeature(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    gocondense.Feature
+		wantErr bool
+	}{
+		{text: "", want: 0},
+		{text: "calls", want: gocondense.Calls},
+		{text: "calls, slices", want: gocondense.Calls | gocondense.Slices},
+		{text: "funcs", want: gocondense.TypeParams | gocondense.Params | gocondense.Results},
+		{text: "signatures", want: gocondense.Declarations | gocondense.Imports | gocondense.Types | gocondense.Funcs | gocondense.Literals},
+		{text: "body-literals", want: gocondense.Calls | gocondense.Structs | gocondense.Slices | gocondense.Arrays | gocondense.Maps},
+		{text: "signatures,body-literals", want: gocondense.Signatures | gocondense.BodyLiterals},
+		{text: "all", want: gocondense.All},
+		{text: "unknown", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var got gocondense.Feature
+			if err := got.UnmarshalText([]byte(tt.text)); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr = %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+			text, _ := got.MarshalText()
+			var roundTrip gocondense.Feature
+			if err := roundTrip.UnmarshalText(text); err != nil || roundTrip != got {
+				t.Errorf("round trip of %q = %v, %v", text, roundTrip, err)
+			}
+		})
+	}
+}
+
+func TestNewline(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    gocondense.Newline
+		wantErr bool
+	}{
+		{text: "always", want: gocondense.NewlineAlways},
+		{text: "keep", want: gocondense.NewlineKeep},
+		{text: "never", want: gocondense.NewlineNever},
+		{text: "", wantErr: true},
+		{text: "sometimes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			var got gocondense.Newline
+			if err := got.UnmarshalText([]byte(tt.text)); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr = %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if text, _ := got.MarshalText(); !tt.wantErr && string(text) != tt.text {
+				t.Errorf("MarshalText() = %q, want %q", text, tt.text)
+			}
+		})
+	}
+}
+
+func toStrings(m map[string][]byte) map[string]string {
+	s := make(map[string]string, len(m))
+	for k, v := range m {
+		s[k] = string(v)
+	}
+	return s
+}
+
+func TestFileChanges(t *testing.T) {
+	src := `package main
+
+import (
+	"fmt"
+)
+
+func greet(
+	first string,
+	last string,
+) string {
+	return fmt.Sprintf(
+		"Hello, %s %s!",
+		first,
+		last,
+	)
+}
+
+var _ = map[string][]int{"a": {
+	1,
+	2,
+},
+}
+
+var _ = run(func() {
+	fmt.Println(1)
+	fmt.Println(2)
+})
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := gocondense.New(gocondense.Config{}).FileChanges(fset, file)
+
+	want := []gocondense.Change{
+		{Feature: gocondense.Imports, Line: 3, EndLine: 5, Before: 17, After: 12},
+		{Feature: gocondense.Params, Line: 7, EndLine: 10, Before: 32, After: 20},
+		{Feature: gocondense.Calls, Line: 11, EndLine: 15, Before: 51, After: 41},
+		{Feature: gocondense.Slices, Line: 18, EndLine: 21, Before: 11, After: 6},
+		{Feature: gocondense.Maps, Line: 18, EndLine: 22, Before: 36, After: 29},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestFileLines(t *testing.T) {
+	src := `package main
+
+var a = f(
+	1,
+)
+
+var b = g(
+	2,
+)
+
+var (
+	c = 3
+)
+`
+	tests := []struct {
+		name  string
+		lines []gocondense.LineRange
+		want  string
+	}{
+		{
+			name:  "none",
+			lines: nil,
+			want:  src,
+		},
+		{
+			name:  "first_line_of_construct",
+			lines: []gocondense.LineRange{{Start: 3, End: 3}},
+			want:  "package main\n\nvar a = f(1)\n\nvar b = g(\n\t2,\n)\n\nvar (\n\tc = 3\n)\n",
+		},
+		{
+			name:  "inside_construct",
+			lines: []gocondense.LineRange{{Start: 8, End: 8}},
+			want:  "package main\n\nvar a = f(\n\t1,\n)\n\nvar b = g(2)\n\nvar (\n\tc = 3\n)\n",
+		},
+		{
+			name:  "spanning_constructs",
+			lines: []gocondense.LineRange{{Start: 5, End: 12}},
+			want:  "package main\n\nvar a = f(1)\n\nvar b = g(2)\n\nvar c = 3\n",
+		},
+		{
+			name:  "between_constructs",
+			lines: []gocondense.LineRange{{Start: 6, End: 6}, {Start: 10, End: 10}},
+			want:  src,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fset := token.NewFileSet()
+			file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gocondense.New(gocondense.Config{}).FileLines(fset, file, tt.lines)
+
+			var buf bytes.Buffer
+			if err := format.Node(&buf, fset, file); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tt.want, buf.String()); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestShouldCondense(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		node func(*ast.File) ast.Node
+		want bool
+	}{
+		{"call", "var _ = f(\n\ta,\n)", first[*ast.CallExpr], true},
+		{"call_single_line", "var _ = f(a)", first[*ast.CallExpr], false},
+		{"call_too_long", "var _ = f(\n\t\"" + strings.Repeat("a", 80) + "\",\n)", first[*ast.CallExpr], false},
+		{"call_comment", "var _ = f(\n\ta, // a\n)", first[*ast.CallExpr], false},
+		{"slice", "var _ = []int{\n\t1,\n}", first[*ast.CompositeLit], true},
+		{"struct_key_not_on_brace_line", "var _ = T{\n\tA: 1,\n}", first[*ast.CompositeLit], false},
+		{"params", "func f(\n\ta int,\n) {\n}", first[*ast.FieldList], true},
+		{"func_lit", "var _ = func() {\n\tf()\n}", first[*ast.FuncLit], true},
+		{"declaration", "var (\n\tx = 1\n)", first[*ast.GenDecl], true},
+		{"binary", "var _ = a +\n\tb", first[*ast.BinaryExpr], true},
+		{"selector", "var _ = a.\n\tb", first[*ast.SelectorExpr], true},
+		{"case_clause", "func f() {\n\tswitch {\n\tcase a,\n\t\tb:\n\t\tg()\n\t}\n}", first[*ast.CaseClause], true},
+		{"nested", "var _ = f(\n\tg(\n\t\ta,\n\t),\n)", func(f *ast.File) ast.Node { return first[*ast.CallExpr](f).(*ast.CallExpr).Args[0] }, true},
+		{"not_in_file", "var _ = f(\n\ta,\n)", func(*ast.File) ast.Node { return &ast.CallExpr{} }, false},
+		{"not_in_copy", "func g() {\n\tf(\n\t\ta,\n\t)\n}", func(f *ast.File) ast.Node {
+			// An implicit empty statement isn't printed, so it has no
+			// counterpart in the re-parsed copy.
+			body := f.Decls[0].(*ast.FuncDecl).Body
+			body.List = append(body.List, &ast.EmptyStmt{Implicit: true})
+			return body.List[len(body.List)-1]
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package main\n\n" + tt.src + "\n"
+			fset := token.NewFileSet()
+			file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := gocondense.New(gocondense.Config{}).ShouldCondense(fset, file, tt.node(file)); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+
+			var buf bytes.Buffer
+			if err := format.Node(&buf, fset, file); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != src {
+				t.Errorf("file was modified:\n%s", buf.String())
+			}
+		})
+	}
+}
+
+func TestCondensedNodes(t *testing.T) {
+	src := `package main
+
+var (
+	x = f(
+		a,
+	)
+)
+
+func g(
+	a int,
+) {
+	switch {
+	case a,
+		b:
+		h()
+	}
+	_ = []int{1}
+}
+`
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := gocondense.New(gocondense.Config{})
+	var got []string
+	for _, n := range f.CondensedNodes(fset, file) {
+		got = append(got, fmt.Sprintf("%d:%T", fset.Position(n.Pos()).Line, n))
+	}
+	want := []string{"4:*ast.CallExpr", "3:*ast.GenDecl", "9:*ast.FieldList", "13:*ast.CaseClause"}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Every node agrees with ShouldCondense.
+	condensed := f.CondensedNodes(fset, file)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n != nil && f.ShouldCondense(fset, file, n) != slices.Contains(condensed, n) {
+			t.Errorf("ShouldCondense disagrees for %T at line %d", n, fset.Position(n.Pos()).Line)
+		}
+		return true
+	})
+}
+
+// first returns the first node of type T in file.
+func first[T ast.Node](file *ast.File) ast.Node {
+	var found ast.Node
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n, ok := n.(T); ok && found == nil {
+			found = n
+		}
+		return found == nil
+	})
+	return found
+}
+
+func TestDiagnose(t *testing.T) {
+	tests := []struct {
+		name   string
+		config gocondense.Config
+		src    string
+		want   gocondense.Change
+	}{
+		{
+			name: "condensed",
+			src:  "var _ = f(\n\ta,\n)",
+			want: gocondense.Change{Feature: gocondense.Calls, Line: 3, EndLine: 5, Reason: gocondense.Condensed, Before: 8, After: 4},
+		},
+		{
+			name:   "disabled",
+			config: gocondense.Config{Features: gocondense.Slices},
+			src:    "var _ = f(\n\ta,\n)",
+			want:   gocondense.Change{Feature: gocondense.Calls, Line: 3, EndLine: 5, Reason: gocondense.Disabled},
+		},
+		{
+			name:   "too_few_lines_saved",
+			config: gocondense.Config{MinLinesSaved: 3},
+			src:    "var _ = f(\n\ta,\n)",
+			want:   gocondense.Change{Feature: gocondense.Calls, Line: 3, EndLine: 5, Reason: gocondense.TooFewLinesSaved},
+		},
+		{
+			name:   "too_many_items",
+			config: gocondense.Config{MaxCondenseItems: 1},
+			src:    "var _ = []int{\n\t1,\n\t2,\n}",
+			want:   gocondense.Change{Feature: gocondense.Slices, Line: 3, EndLine: 6, Reason: gocondense.TooManyItems},
+		},
+		{
+			name: "has_comments",
+			src:  "var _ = f(\n\ta, // comment\n)",
+			want: gocondense.Change{Feature: gocondense.Calls, Line: 3, EndLine: 5, Reason: gocondense.HasComments},
+		},
+		{
+			name: "multi_line_element",
+			src:  "var _ = f(\n\tfunc() {\n\t\ta()\n\t\tb()\n\t},\n\tc,\n)",
+			want: gocondense.Change{Feature: gocondense.Calls, Line: 3, EndLine: 9, Reason: gocondense.MultiLineElement},
+		},
+		{
+			name: "key_not_on_brace_line",
+			src:  "var _ = T{\n\tA: 1,\n}",
+			want: gocondense.Change{Feature: gocondense.Structs, Line: 3, EndLine: 5, Reason: gocondense.KeyNotOnBraceLine},
+		},
+		{
+			name: "qualified_generic_struct",
+			src:  "var _ = &pkg.Box[int]{\n\tA: 1,\n}",
+			want: gocondense.Change{Feature: gocondense.Structs, Line: 3, EndLine: 5, Reason: gocondense.KeyNotOnBraceLine},
+		},
+		{
+			name: "too_long",
+			src:  "var _ = f(\n\t\"" + strings.Repeat("a", 80) + "\",\n)",
+			want: gocondense.Change{Feature: gocondense.Calls, Line: 3, EndLine: 5, Reason: gocondense.TooLong},
+		},
+		{
+			name:   "too_deep",
+			config: gocondense.Config{MaxNestDepth: 1},
+			src:    "var _ = f(\n\tg(),\n)",
+			want:   gocondense.Change{Feature: gocondense.Calls, Line: 3, EndLine: 5, Reason: gocondense.TooDeep},
+		},
+		{
+			name:   "preserved_iota",
+			config: gocondense.Config{PreserveIotaBlocks: true},
+			src:    "const (\n\tA = iota\n)",
+			want:   gocondense.Change{Feature: gocondense.Declarations, Line: 3, EndLine: 5, Reason: gocondense.PreservedIota},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := "package main\n\n" + tt.src + "\n"
+			fset := token.NewFileSet()
+			file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := gocondense.New(tt.config).Diagnose(fset, file)
+			if diff := cmp.Diff([]gocondense.Change{tt.want}, got); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestMaxChangesPerFile(t *testing.T) {
+	src := "package main\n\nvar a = f(\n\t1,\n)\n\nvar b = []int{\n\t2,\n}\n\nvar (\n\tc = 3\n)\n"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := gocondense.New(gocondense.Config{MaxChangesPerFile: 1}).Diagnose(fset, file)
+
+	want := []gocondense.Change{
+		{Feature: gocondense.Calls, Line: 3, EndLine: 5, Reason: gocondense.Condensed, Before: 8, After: 4},
+		{Feature: gocondense.Slices, Line: 7, EndLine: 9, Reason: gocondense.ChangeLimit},
+		{Feature: gocondense.Declarations, Line: 11, EndLine: 13, Reason: gocondense.ChangeLimit},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestAccept(t *testing.T) {
+	src := "package main\n\nvar a = map[string]int{\"a\": 1,\n\t\"b\": 2,\n}\n\nvar b = []int{\n\t1,\n}\n\nvar (\n\tc = 3\n)\n" +
+		"\nfunc f[\n\tT any,\n](\n\tx T,\n) {}\n\ntype g[\n\tT any,\n] struct{}\n"
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var candidates []string
+	formatter := gocondense.New(gocondense.Config{
+		Accept: func(feature gocondense.Feature, _ ast.Node, condensed string) bool {
+			candidates = append(candidates, condensed)
+			return feature != gocondense.Maps
+		},
+	})
+	got := formatter.Diagnose(fset, file)
+
+	want := []gocondense.Change{
+		{Feature: gocondense.Maps, Line: 3, EndLine: 5, Reason: gocondense.Rejected},
+		{Feature: gocondense.Slices, Line: 7, EndLine: 9, Reason: gocondense.Condensed, Before: 12, After: 8},
+		{Feature: gocondense.Declarations, Line: 11, EndLine: 13, Reason: gocondense.Condensed, Before: 14, After: 9},
+		{Feature: gocondense.TypeParams, Line: 15, EndLine: 17, Reason: gocondense.Condensed, Before: 11, After: 7},
+		{Feature: gocondense.Params, Line: 17, EndLine: 19, Reason: gocondense.Condensed, Before: 9, After: 5},
+		{Feature: gocondense.Types, Line: 21, EndLine: 23, Reason: gocondense.Condensed, Before: 11, After: 7},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Error(diff)
+	}
+
+	wantCandidates := []string{`map[string]int{"a": 1, "b": 2}`, "[]int{1}", "var c = 3", "[T any]", "(x T)", "[T any]"}
+	if diff := cmp.Diff(wantCandidates, candidates); diff != "" {
+		t.Error(diff)
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, file); err != nil {
+		t.Fatal(err)
+	}
+	wantSrc := "package main\n\nvar a = map[string]int{\"a\": 1,\n\t\"b\": 2,\n}\n\nvar b = []int{1}\n\nvar c = 3\n" +
+		"\nfunc f[T any](x T) {}\n\ntype g[T any] struct{}\n"
+	if diff := cmp.Diff(wantSrc, buf.String()); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestFormatterConcurrent(t *testing.T) {
+	formatter := gocondense.New(gocondense.Config{})
+	input := []byte("package main\n\nvar _ = []int{\n\t1,\n\t2,\n}\n")
+	want := "package main\n\nvar _ = []int{1, 2}\n"
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Go(func() {
+			got, err := formatter.Source(input)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if diff := cmp.Diff(want, string(got)); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+	wg.Wait()
+}
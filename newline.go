@@ -0,0 +1,60 @@
+package gocondense
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+// Newline controls whether formatted output ends with a newline.
+type Newline uint8
+
+// Final newline modes.
+const (
+	NewlineAlways Newline = iota // always end with a newline, like gofmt
+	NewlineKeep                  // end with a newline only if the input did
+	NewlineNever                 // never end with a newline
+)
+
+var newlineNames = []string{
+	"always",
+	"keep",
+	"never",
+}
+
+// String returns the name of the mode.
+func (n Newline) String() string {
+	if int(n) < len(newlineNames) {
+		return newlineNames[n]
+	}
+	return "unknown"
+}
+
+// MarshalText implements [encoding.TextMarshaler].
+func (n Newline) MarshalText() ([]byte, error) {
+	return []byte(n.String()), nil
+}
+
+// UnmarshalText implements [encoding.TextUnmarshaler]. It accepts "always",
+// "keep" or "never".
+func (n *Newline) UnmarshalText(text []byte) error {
+	i := slices.Index(newlineNames, string(text))
+	if i < 0 {
+		return fmt.Errorf("unknown newline mode %q", text)
+	}
+	*n = Newline(i)
+	return nil
+}
+
+// apply adjusts the trailing newline of out, formatted from src.
+func (n Newline) apply(src, out []byte) []byte {
+	switch n {
+	case NewlineKeep:
+		if !bytes.HasSuffix(src, []byte("\n")) {
+			return bytes.TrimSuffix(out, []byte("\n"))
+		}
+	case NewlineNever:
+		return bytes.TrimSuffix(out, []byte("\n"))
+	}
+	return out
+}
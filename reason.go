@@ -0,0 +1,43 @@
+package gocondense
+
+// Reason explains why a construct was or wasn't condensed.
+type Reason uint8
+
+// Reasons reported by [Formatter.Diagnose].
+const (
+	Condensed         Reason = iota // the construct was condensed
+	Disabled                        // the construct's feature is not enabled
+	TooFewLinesSaved                // condensing would save fewer than MinLinesSaved lines
+	TooManyItems                    // the list has more than MaxCondenseItems elements
+	HasComments                     // the construct contains comments
+	MultiLineElement                // an element of the construct spans multiple lines
+	KeyNotOnBraceLine               // a keyed literal's first element is not on the brace line
+	PreservedIota                   // the group uses iota and PreserveIotaBlocks is set
+	TooLong                         // the condensed construct would exceed MaxLen
+	TooDeep                         // the condensed construct would nest deeper than MaxNestDepth
+	ChangeLimit                     // MaxChangesPerFile constructs were already condensed
+	Rejected                        // the Accept callback rejected the condensed construct
+)
+
+var reasonNames = []string{
+	"condensed",
+	"disabled",
+	"too few lines saved",
+	"too many items",
+	"has comments",
+	"multi-line element",
+	"key not on brace line",
+	"preserved iota",
+	"too long",
+	"too deep",
+	"change limit",
+	"rejected",
+}
+
+// String returns a short description of the reason.
+func (r Reason) String() string {
+	if int(r) < len(reasonNames) {
+		return reasonNames[r]
+	}
+	return "unknown"
+}